@@ -1,2 +1,5 @@
 go get -u all
-go build -o api.exe -ldflags "-s -w" src/main.go`       
\ No newline at end of file
+$version = git describe --tags --always --dirty 2>$null; if (-not $version) { $version = "dev" }
+$commit = git rev-parse --short HEAD 2>$null; if (-not $commit) { $commit = "dev" }
+$buildTime = (Get-Date).ToUniversalTime().ToString("yyyy-MM-ddTHH:mm:ssZ")
+go build -o api.exe -ldflags "-s -w -X main.version=$version -X main.commit=$commit -X main.buildTime=$buildTime" ./src
\ No newline at end of file
@@ -1,3 +1,6 @@
 #!/bin/bash
 go get -u all
-go build -o api -ldflags "-s -w" src/main.go
\ No newline at end of file
+VERSION=$(git describe --tags --always --dirty 2>/dev/null || echo dev)
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo dev)
+BUILD_TIME=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+go build -o api -ldflags "-s -w -X main.version=$VERSION -X main.commit=$COMMIT -X main.buildTime=$BUILD_TIME" ./src
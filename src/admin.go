@@ -0,0 +1,55 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// adminPage is the built-in dashboard. It only calls the public JSON
+// endpoints plus /admin/events.
+//
+//go:embed assets/admin.html
+var adminPage []byte
+
+// requireAdminToken protects /admin with UPLOAD_TOKEN. Besides the Bearer
+// header it accepts HTTP Basic auth with the token as the password, so a
+// browser can open the page directly and reuse the credentials for fetches.
+func requireAdminToken(c *fiber.Ctx) error {
+	if uploadToken == "" {
+		return sendError(c, fiber.StatusForbidden, "admin is disabled")
+	}
+	auth := c.Get(fiber.HeaderAuthorization)
+	if token, ok := strings.CutPrefix(auth, "Bearer "); ok && validUploadToken(token) {
+		return c.Next()
+	}
+	if encoded, ok := strings.CutPrefix(auth, "Basic "); ok {
+		if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+			if _, password, ok := strings.Cut(string(decoded), ":"); ok && validUploadToken(password) {
+				return c.Next()
+			}
+		}
+	}
+	c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="gary-api admin"`)
+	return sendError(c, fiber.StatusUnauthorized, "missing or invalid token")
+}
+
+func serveAdminHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.Status(fiber.StatusOK).Send(adminPage)
+}
+
+// serveAdminEventsHandler returns the recent watcher events of every category.
+func serveAdminEventsHandler(categories []*Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		events := make(map[string][]watchEvent, len(categories))
+		for _, cat := range categories {
+			events[cat.name] = cat.events.recent()
+		}
+		return c.Status(fiber.StatusOK).JSON(events)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	recencyCurveLinear      = "linear"
+	recencyCurveExponential = "exponential"
+
+	// recencyScale keeps exponential weights integral: the newest image weighs
+	// this much and older ones decay toward a floor of 1.
+	recencyScale = 1_000_000
+)
+
+var (
+	recencyCurve = recencyCurveLinear
+	// recencyHalfLife is how many numbers back an image's weight halves on
+	// the exponential curve (RECENCY_HALF_LIFE).
+	recencyHalfLife = 10
+)
+
+// recencyCurveFromEnv reads RECENCY_CURVE, falling back to linear when it is
+// unset or invalid.
+func recencyCurveFromEnv() string {
+	switch raw := os.Getenv("RECENCY_CURVE"); raw {
+	case "":
+		return recencyCurveLinear
+	case recencyCurveLinear, recencyCurveExponential:
+		return raw
+	default:
+		fmt.Printf("Invalid RECENCY_CURVE %q, using %s\n", raw, recencyCurveLinear)
+		return recencyCurveLinear
+	}
+}
+
+// selectionWeights returns the weights for a random pick from pool: cat.weights
+// for the default uniform bias, or those scaled by image number for
+// ?bias=recent. Callers must hold imageCacheMu for reading.
+func selectionWeights(c *fiber.Ctx, cat *Category, pool []string) (map[string]int, *fiber.Error) {
+	switch c.Query("bias") {
+	case "", "uniform":
+		return cat.weights, nil
+	case "recent":
+		return recencyWeights(pool, cat.weights), nil
+	default:
+		return nil, fiber.NewError(fiber.StatusBadRequest, "bias must be uniform or recent")
+	}
+}
+
+// recencyWeights weights every image in pool by its number relative to the
+// pool's oldest (linear) or newest (exponential) image, multiplied by its
+// configured weight. Images without a number count as number 0.
+func recencyWeights(pool []string, base map[string]int) map[string]int {
+	if len(pool) == 0 {
+		return base
+	}
+	lowest, highest := math.MaxInt, math.MinInt
+	for _, name := range pool {
+		n := extractNumberFromFilename(name)
+		lowest, highest = min(lowest, n), max(highest, n)
+	}
+
+	weights := make(map[string]int, len(pool))
+	for _, name := range pool {
+		n := extractNumberFromFilename(name)
+		var recency int
+		if recencyCurve == recencyCurveExponential {
+			recency = max(int(recencyScale*math.Exp2(float64(n-highest)/float64(recencyHalfLife))), 1)
+		} else {
+			recency = n - lowest + 1
+		}
+		weights[name] = imageWeight(base, name) * recency
+	}
+	return weights
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// breakerFailures is how many consecutive image read failures within
+	// breakerWindow open a category's circuit breaker (BREAKER_FAILURES).
+	// 0 disables the breaker.
+	breakerFailures int
+	breakerWindow   = 30 * time.Second
+	// breakerCooldown is how long an open breaker rejects image requests
+	// before letting a single probe through (BREAKER_COOLDOWN).
+	breakerCooldown = 30 * time.Second
+	// breakerReadTimeout bounds each stat, hash, and header decode on the
+	// image routes, so a hung mount counts as a failure instead of piling up
+	// goroutines (BREAKER_READ_TIMEOUT).
+	breakerReadTimeout = 2 * time.Second
+
+	errReadTimeout = errors.New("timed out reading image storage")
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// circuitBreaker short-circuits a category's image reads while its storage
+// keeps failing. A nil *circuitBreaker is valid and always allows reads.
+type circuitBreaker struct {
+	label string
+
+	mu           sync.Mutex
+	state        string
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+}
+
+type breakerStatus struct {
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+	// RetryInMs is how long an open breaker waits before its next probe.
+	RetryInMs int64 `json:"retry_in_ms,omitempty"`
+}
+
+func newCircuitBreaker(label string) *circuitBreaker {
+	if breakerFailures <= 0 {
+		return nil
+	}
+	return &circuitBreaker{label: label, state: breakerClosed}
+}
+
+// allow reports whether a read may go ahead. Once the cooldown has passed an
+// open breaker goes half-open and admits one probe at a time; probe is true
+// for that request, which must call release when done.
+func (b *circuitBreaker) allow() (probe, ok bool) {
+	if b == nil {
+		return false, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerClosed:
+		return false, true
+	case breakerOpen:
+		if time.Since(b.openedAt) < breakerCooldown {
+			return false, false
+		}
+		b.state = breakerHalfOpen
+	}
+	if b.probing {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+// release ends a probe admitted by allow. A probe that never reached the
+// disk (a 404, a bad parameter) leaves the breaker half-open for the next.
+func (b *circuitBreaker) release(probe bool) {
+	if b == nil || !probe {
+		return
+	}
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+func (b *circuitBreaker) success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerClosed {
+		fmt.Printf("[%s] Image storage recovered, circuit breaker closed\n", b.label)
+	}
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+func (b *circuitBreaker) failure(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	switch b.state {
+	case breakerOpen:
+		return
+	case breakerHalfOpen:
+		b.state, b.openedAt = breakerOpen, now
+		fmt.Printf("[%s] Probe failed (%v), circuit breaker open for another %s\n", b.label, err, breakerCooldown)
+		return
+	}
+	if b.failures == 0 || now.Sub(b.firstFailure) > breakerWindow {
+		b.failures, b.firstFailure = 0, now
+	}
+	b.failures++
+	if b.failures >= breakerFailures {
+		b.state, b.openedAt = breakerOpen, now
+		fmt.Printf("[%s] %d image read failures in a row (last: %v), circuit breaker open for %s\n", b.label, b.failures, err, breakerCooldown)
+	}
+}
+
+func (b *circuitBreaker) status() breakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := breakerStatus{State: b.state, Failures: b.failures}
+	if b.state == breakerOpen {
+		status.RetryInMs = max(breakerCooldown-time.Since(b.openedAt), 0).Milliseconds()
+	}
+	return status
+}
+
+// readStorage runs read, bounded by breakerReadTimeout while cat has a
+// breaker, and counts a storage error as a breaker failure. A read that times
+// out keeps running in the background.
+func readStorage[T any](cat *Category, read func() (T, error)) (T, error) {
+	if cat.breaker == nil || breakerReadTimeout <= 0 {
+		return read()
+	}
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := read()
+		done <- result{value, err}
+	}()
+	timer := time.NewTimer(breakerReadTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if isStorageError(r.err) {
+			cat.breaker.failure(r.err)
+		}
+		return r.value, r.err
+	case <-timer.C:
+		cat.breaker.failure(errReadTimeout)
+		var zero T
+		return zero, errReadTimeout
+	}
+}
+
+// isStorageError reports whether err came from the storage itself, a failed
+// or timed-out read, rather than a missing file or undecodable image data.
+func isStorageError(err error) bool {
+	var pathErr *fs.PathError
+	return errors.Is(err, errReadTimeout) || errors.As(err, &pathErr) && !errors.Is(err, fs.ErrNotExist)
+}
+
+// statImage is os.Stat through readStorage.
+func (cat *Category) statImage(path string) (os.FileInfo, error) {
+	return readStorage(cat, func() (os.FileInfo, error) { return os.Stat(path) })
+}
+
+// imageDimensions is getImageDimensions through readStorage.
+func (cat *Category) imageDimensions(path string) (imageDimensions, error) {
+	return readStorage(cat, func() (imageDimensions, error) { return getImageDimensions(path) })
+}
+
+// fileSHA256 is getFileSHA256 through readStorage.
+func (cat *Category) fileSHA256(path string) (string, error) {
+	return readStorage(cat, func() (string, error) { return getFileSHA256(path) })
+}
+
+// readImage is os.ReadFile through readStorage.
+func (cat *Category) readImage(path string) ([]byte, error) {
+	return readStorage(cat, func() ([]byte, error) { return os.ReadFile(path) })
+}
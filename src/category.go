@@ -0,0 +1,450 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	defaultCategories      = "gary,goober,gully"
+	defaultImageExtensions = ".jpg,.jpeg,.png,.gif,.webp"
+	weightsFileName        = "weights.json"
+)
+
+// knownDefaultImages keeps the historical fallback images for the built-in
+// categories. Any category can set <NAME>_DEFAULT or <NAME>_DEFAULTS.
+var knownDefaultImages = map[string]string{
+	"gary":   "Gary76.jpg",
+	"goober": "goober8.jpg",
+	"gully":  "Gully1.jpg",
+}
+
+var (
+	// imageCacheMu guards the images and weights of every Category.
+	imageCacheMu sync.RWMutex
+
+	allowedExtensions = parseExtensions(defaultImageExtensions)
+
+	// watchDebounce is the quiet period WATCH_DEBOUNCE waits for before
+	// rescanning after a burst of directory events. 0 rescans on every event.
+	watchDebounce = 500 * time.Millisecond
+
+	// recursiveScan makes categories include images in subdirectories,
+	// stored as slash-separated paths relative to the category directory.
+	recursiveScan bool
+)
+
+// Category is one image collection, e.g. gary. Its routes, directory, and
+// URLs are all derived from the name by convention.
+type Category struct {
+	name          string
+	label         string
+	dir           string
+	defaultImages []string
+	baseURL       string
+	images        []string
+	weights       map[string]int
+	payloads      *payloadCache
+	reads         chan struct{}
+	breaker       *circuitBreaker
+	events        *eventLog
+	// pool is what random selection draws from: images, minus duplicates
+	// under DEDUP.
+	pool []string
+	// fallbackDir (<NAME>_DIR_FALLBACK) is served from while dir is empty or
+	// unreadable, and embeddedDir (the unpacked EMBEDDED_FALLBACK set) while
+	// both are. source records which of the three rescan picked.
+	fallbackDir string
+	embeddedDir string
+	source      atomic.Int32
+
+	pickMu     sync.Mutex
+	lastPicked string
+	recent     []string
+
+	statsMu sync.Mutex
+	stats   *categoryStats
+}
+
+// The directories a category can serve from, see Category.source.
+const (
+	sourceDir int32 = iota
+	sourceFallback
+	sourceEmbedded
+)
+
+func envPrefix(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func newCategory(name string) *Category {
+	prefix := envPrefix(name)
+	label := strings.ToUpper(name[:1]) + name[1:]
+	return &Category{
+		name:          name,
+		label:         label,
+		dir:           os.Getenv(prefix + "_DIR"),
+		fallbackDir:   os.Getenv(prefix + "_DIR_FALLBACK"),
+		defaultImages: parseDefaultImages(name),
+		baseURL:       os.Getenv(prefix + "URL"),
+		payloads:      newPayloadCache(payloadCacheSize),
+		reads:         newReadSlots(maxConcurrentReads),
+		breaker:       newCircuitBreaker(label),
+		events:        newEventLog(watcherEventCount),
+	}
+}
+
+// parseDefaultImages reads <NAME>_DEFAULTS (comma-separated), then the single
+// <NAME>_DEFAULT, then the built-in default for the category.
+func parseDefaultImages(name string) []string {
+	prefix := envPrefix(name)
+	var defaults []string
+	for _, image := range strings.Split(os.Getenv(prefix+"_DEFAULTS"), ",") {
+		if image = strings.TrimSpace(image); image != "" {
+			defaults = append(defaults, image)
+		}
+	}
+	if len(defaults) > 0 {
+		return defaults
+	}
+	if image := os.Getenv(prefix + "_DEFAULT"); image != "" {
+		return []string{image}
+	}
+	if image, ok := knownDefaultImages[name]; ok {
+		return []string{image}
+	}
+	return nil
+}
+
+// loadCategories builds the registry from CATEGORIES (comma-separated),
+// defaulting to gary, goober, and gully.
+func loadCategories() []*Category {
+	raw := os.Getenv("CATEGORIES")
+	if raw == "" {
+		raw = defaultCategories
+	}
+
+	var categories []*Category
+	seen := map[string]bool{}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		categories = append(categories, newCategory(name))
+	}
+	return categories
+}
+
+// parseExtensions turns a comma-separated list like ".jpg,png" into a
+// lower-cased lookup set. Leading dots are optional.
+func parseExtensions(raw string) map[string]bool {
+	exts := map[string]bool{}
+	for _, ext := range strings.Split(raw, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts[ext] = true
+	}
+	return exts
+}
+
+func hasAllowedExtension(name string) bool {
+	return allowedExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+func cacheFileNames(dirPath string) []string {
+	if recursiveScan {
+		return walkFileNames(dirPath)
+	}
+	files, err := os.ReadDir(dirPath)
+	if err != nil {
+		fmt.Printf("Error reading dir %s: %v\n", dirPath, err)
+		return nil
+	}
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() && hasAllowedExtension(file.Name()) {
+			names = append(names, file.Name())
+		}
+	}
+	return names
+}
+
+func walkFileNames(dirPath string) []string {
+	var names []string
+	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dirPath {
+				return err
+			}
+			fmt.Printf("Error reading %s: %v\n", path, err)
+			return nil
+		}
+		if d.IsDir() || !hasAllowedExtension(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dirPath, path)
+		if err != nil {
+			return nil
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Error reading dir %s: %v\n", dirPath, err)
+		return nil
+	}
+	return names
+}
+
+// loadWeights reads the optional weights.json in dir, mapping filename to an
+// integer weight. A missing or malformed file yields nil (uniform selection).
+func loadWeights(dir string) map[string]int {
+	data, err := os.ReadFile(filepath.Join(dir, weightsFileName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Error reading %s in %s: %v\n", weightsFileName, dir, err)
+		}
+		return nil
+	}
+
+	var weights map[string]int
+	if err := json.Unmarshal(data, &weights); err != nil {
+		fmt.Printf("Ignoring malformed %s in %s: %v\n", weightsFileName, dir, err)
+		return nil
+	}
+	return weights
+}
+
+// imageDir is the directory cat's images are currently served from: dir,
+// or fallbackDir or embeddedDir while one of those is in effect.
+func (cat *Category) imageDir() string {
+	switch cat.source.Load() {
+	case sourceFallback:
+		return cat.fallbackDir
+	case sourceEmbedded:
+		return cat.embeddedDir
+	}
+	return cat.dir
+}
+
+// describeSource names source for log lines.
+func (cat *Category) describeSource(source int32) string {
+	switch source {
+	case sourceFallback:
+		return fmt.Sprintf("fallback %q", cat.fallbackDir)
+	case sourceEmbedded:
+		return "the embedded fallback set"
+	}
+	return fmt.Sprintf("%q", cat.dir)
+}
+
+// rescan reloads the image list and weights from disk, switching to
+// fallbackDir, then embeddedDir, while dir has no images and back once it
+// does.
+func (cat *Category) rescan() {
+	dir, source := cat.dir, sourceDir
+	images := cacheFileNames(dir)
+	if len(images) == 0 && cat.fallbackDir != "" {
+		if standby := cacheFileNames(cat.fallbackDir); len(standby) > 0 {
+			dir, images, source = cat.fallbackDir, standby, sourceFallback
+		}
+	}
+	if len(images) == 0 && cat.embeddedDir != "" {
+		if standby := cacheFileNames(cat.embeddedDir); len(standby) > 0 {
+			dir, images, source = cat.embeddedDir, standby, sourceEmbedded
+		}
+	}
+	weights := loadWeights(dir)
+	pool := images
+	if dedupImages {
+		pool = dedupByContent(cat, dir, images)
+	}
+
+	imageCacheMu.Lock()
+	cat.images = images
+	cat.pool = pool
+	cat.weights = weights
+	previous := cat.source.Swap(source)
+	imageCacheMu.Unlock()
+	if source != previous && source == sourceDir {
+		fmt.Printf("[%s] Images are back in %q, leaving %s\n", cat.label, cat.dir, cat.describeSource(previous))
+	} else if source != previous {
+		fmt.Printf("[%s] No images in %q, serving from %s\n", cat.label, cat.dir, cat.describeSource(source))
+	}
+	cat.payloads.clear()
+	cat.invalidateStats()
+}
+
+// warmUp loads the image list and logs how many images were found and how
+// long it took. With decodeHeaders it also fills the dimension cache for
+// every image, reporting files whose headers can't be decoded.
+func (cat *Category) warmUp(decodeHeaders bool) {
+	start := time.Now()
+	cat.rescan()
+
+	imageCacheMu.RLock()
+	images := cat.images
+	imageCacheMu.RUnlock()
+
+	if !decodeHeaders {
+		fmt.Printf("[%s] Loaded %d images in %s\n", cat.label, len(images), time.Since(start).Round(time.Microsecond))
+		return
+	}
+	failed := 0
+	for _, name := range images {
+		if _, err := getImageDimensions(filepath.Join(cat.imageDir(), name)); err != nil {
+			failed++
+			fmt.Printf("[%s] %v\n", cat.label, err)
+		}
+	}
+	fmt.Printf("[%s] Loaded %d images and decoded headers (%d failed) in %s\n", cat.label, len(images), failed, time.Since(start).Round(time.Microsecond))
+}
+
+// startDirectoryWatcher watches dir, either cat's directory or its fallback,
+// rescanning cat on changes. dir's parent is watched too, so a directory that
+// is missing at startup, or removed and recreated by a remount or storage
+// swap, is picked up again when it appears.
+func startDirectoryWatcher(cat *Category, dir string) *fsnotify.Watcher {
+	label := cat.label
+	if dir == "" {
+		fmt.Printf("Failed to watch directory %s: no directory set\n", label)
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Printf("Failed to create watcher for %s: %v\n", label, err)
+		return nil
+	}
+	dir = filepath.Clean(dir)
+	parent := filepath.Dir(dir)
+	parentErr := watcher.Add(parent)
+	if err = watcher.Add(dir); err != nil && parentErr != nil {
+		fmt.Printf("Failed to watch directory %s: %v\n", dir, err)
+		watcher.Close()
+		return nil
+	}
+	if err != nil {
+		fmt.Printf("[%s] Cannot watch %s yet (%v), waiting for it to appear\n", label, dir, err)
+	} else if recursiveScan {
+		watchSubdirectories(watcher, dir, label)
+	}
+
+	go func() {
+		defer watcher.Close()
+		// The first change after a quiet period rescans at once; the rest of
+		// a burst (a bulk copy) is coalesced into one rescan once it settles.
+		var lastRescan time.Time
+		coalesced := 0
+		debounce := time.NewTimer(0)
+		if !debounce.Stop() {
+			<-debounce.C
+		}
+		for {
+			select {
+			case <-debounce.C:
+				cat.rescan()
+				lastRescan = time.Now()
+				fmt.Printf("[%s] Cache updated after %d coalesced events\n", label, coalesced)
+				coalesced = 0
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// Of the parent's events only dir itself matters.
+				if parent != dir && filepath.Dir(event.Name) == parent && event.Name != dir {
+					continue
+				}
+				if event.Name == dir {
+					// The files behind every cached path may have changed.
+					clearFileCaches()
+					if event.Op&fsnotify.Create != 0 {
+						if err := watcher.Add(dir); err != nil {
+							fmt.Printf("Failed to watch directory %s: %v\n", dir, err)
+						} else if recursiveScan {
+							watchSubdirectories(watcher, dir, label)
+						}
+						fmt.Printf("[%s] %s appeared, watching it again\n", label, dir)
+					}
+				}
+				cat.recordEvent(dir, event)
+				if event.Op&(fsnotify.Create|fsnotify.Remove|fsnotify.Rename|fsnotify.Write) != 0 {
+					invalidateFileCaches(event.Name)
+					cat.payloads.clear()
+					cat.invalidateStats()
+				}
+				if recursiveScan && event.Op&fsnotify.Create != 0 {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						watchSubdirectories(watcher, event.Name, label)
+					}
+				}
+				isWeights := filepath.Base(event.Name) == weightsFileName
+				if event.Op&(fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 || (isWeights && event.Op&fsnotify.Write != 0) {
+					if coalesced == 0 && time.Since(lastRescan) >= watchDebounce {
+						cat.rescan()
+						lastRescan = time.Now()
+						fmt.Printf("[%s] Cache updated due to event: %s\n", label, event)
+					} else {
+						coalesced++
+						debounce.Reset(jitteredDebounce())
+					}
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Printf("[%s] Watcher error: %v\n", label, err)
+			}
+		}
+	}()
+	return watcher
+}
+
+// jitteredDebounce is watchDebounce plus up to 20%, so categories hit by the
+// same bulk copy don't all rescan in the same instant.
+func jitteredDebounce() time.Duration {
+	if watchDebounce <= 0 {
+		return 0
+	}
+	return watchDebounce + time.Duration(randIntN(int(watchDebounce/5)+1))
+}
+
+// recordEvent adds event to cat's event log, naming the file relative to the
+// watched directory like the cached image names.
+func (cat *Category) recordEvent(dir string, event fsnotify.Event) {
+	name := event.Name
+	if rel, err := filepath.Rel(dir, event.Name); err == nil {
+		name = filepath.ToSlash(rel)
+	}
+	cat.events.add(watchEvent{Timestamp: time.Now().UTC(), Op: event.Op.String(), Filename: name})
+}
+
+// watchSubdirectories adds root and every directory below it to watcher.
+// fsnotify is not recursive, so new folders are added as they appear.
+func watchSubdirectories(watcher *fsnotify.Watcher, root, label string) {
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if err := watcher.Add(path); err != nil {
+			fmt.Printf("[%s] Failed to watch directory %s: %v\n", label, path, err)
+		}
+		return nil
+	})
+}
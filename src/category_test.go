@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitForSource polls until cat serves from want, failing after a few
+// seconds.
+func waitForSource(t *testing.T, cat *Category, want int32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for cat.source.Load() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("source is %d, want %d", cat.source.Load(), want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func writeImage(t *testing.T, dir, name string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newWatchedCategory watches primary and fallback like main does. Events are
+// not debounced, so a late rescan can't hide a lost watch.
+func newWatchedCategory(t *testing.T, primary, fallback string) *Category {
+	t.Helper()
+	debounce := watchDebounce
+	watchDebounce = 0
+	t.Cleanup(func() { watchDebounce = debounce })
+	t.Setenv("WATCHTEST_DIR", primary)
+	t.Setenv("WATCHTEST_DIR_FALLBACK", fallback)
+	cat := newCategory("watchtest")
+	cat.rescan()
+	for _, dir := range []string{cat.dir, cat.fallbackDir} {
+		watcher := startDirectoryWatcher(cat, dir)
+		if watcher == nil {
+			t.Fatalf("could not watch %s", dir)
+		}
+		t.Cleanup(func() { watcher.Close() })
+	}
+	return cat
+}
+
+func TestWatcherFollowsRecreatedPrimary(t *testing.T) {
+	root := t.TempDir()
+	primary, fallback := filepath.Join(root, "primary"), filepath.Join(root, "fallback")
+	for _, dir := range []string{primary, fallback} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeImage(t, primary, "watchtest1.png")
+	writeImage(t, fallback, "watchtest2.png")
+
+	cat := newWatchedCategory(t, primary, fallback)
+	waitForSource(t, cat, sourceDir)
+
+	if err := os.RemoveAll(primary); err != nil {
+		t.Fatal(err)
+	}
+	waitForSource(t, cat, sourceFallback)
+
+	if err := os.Mkdir(primary, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeImage(t, primary, "watchtest3.png")
+	waitForSource(t, cat, sourceDir)
+}
+
+func TestWatcherPicksUpPrimaryMissingAtStartup(t *testing.T) {
+	root := t.TempDir()
+	primary, fallback := filepath.Join(root, "primary"), filepath.Join(root, "fallback")
+	if err := os.Mkdir(fallback, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeImage(t, fallback, "watchtest1.png")
+
+	cat := newWatchedCategory(t, primary, fallback)
+	waitForSource(t, cat, sourceFallback)
+
+	staging := filepath.Join(root, "staging")
+	if err := os.Mkdir(staging, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeImage(t, staging, "watchtest2.png")
+	if err := os.Rename(staging, primary); err != nil {
+		t.Fatal(err)
+	}
+	waitForSource(t, cat, sourceDir)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2/middleware/compress"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+)
+
+var (
+	// randomCacheControl applies to endpoints whose response changes per request.
+	randomCacheControl = "no-store"
+	// imageCacheControl applies to deterministic image responses: by number,
+	// by name, and the static mounts.
+	imageCacheControl = "public, max-age=3600"
+	// routePrefix is the ROUTE_PREFIX every route is mounted under, like
+	// "/api/v1", or "" for the root.
+	routePrefix string
+)
+
+// normalizeRoutePrefix gives raw a leading slash and no trailing one, so
+// "api/v1/" becomes "/api/v1" and "/" becomes "".
+func normalizeRoutePrefix(raw string) string {
+	raw = strings.Trim(strings.TrimSpace(raw), "/")
+	if raw == "" {
+		return ""
+	}
+	return "/" + raw
+}
+
+// trustedProxiesFromEnv parses TRUSTED_PROXIES, a comma-separated list of
+// IPs and CIDRs, dropping entries that are neither. Only requests from these
+// addresses may set the client IP through PROXY_HEADER.
+func trustedProxiesFromEnv() []string {
+	var proxies []string
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			fmt.Printf("Ignoring invalid TRUSTED_PROXIES entry %q\n", entry)
+			continue
+		}
+		proxies = append(proxies, entry)
+	}
+	return proxies
+}
+
+// tlsFromEnv returns TLS_CERT_FILE and TLS_KEY_FILE, or two empty strings
+// for plain HTTP. Setting only one of them, or naming a file that can't be
+// read, is an error.
+func tlsFromEnv() (certFile, keyFile string, err error) {
+	certFile, keyFile = os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return "", "", nil
+	}
+	if certFile == "" || keyFile == "" {
+		return "", "", errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if err := checkFileReadable(certFile); err != nil {
+		return "", "", fmt.Errorf("TLS_CERT_FILE: %w", err)
+	}
+	if err := checkFileReadable(keyFile); err != nil {
+		return "", "", fmt.Errorf("TLS_KEY_FILE: %w", err)
+	}
+	return certFile, keyFile, nil
+}
+
+// validateConfig checks that every category directory is readable and the
+// quote/joke files parse, returning one message per failing env var. A
+// readable <NAME>_DIR_FALLBACK covers for an unreadable directory, and the
+// embedded set for an unset or unreadable one.
+func validateConfig(categories []*Category) []string {
+	var problems []string
+	for _, cat := range categories {
+		key := envPrefix(cat.name) + "_DIR"
+		if _, ok := embeddedFallbackSet(cat.name); ok {
+			continue
+		}
+		if cat.dir == "" {
+			problems = append(problems, fmt.Sprintf("%s is not set", key))
+			continue
+		}
+		if _, err := os.ReadDir(cat.dir); err != nil {
+			if cat.fallbackDir != "" {
+				if _, fallbackErr := os.ReadDir(cat.fallbackDir); fallbackErr == nil {
+					continue
+				}
+			}
+			problems = append(problems, fmt.Sprintf("%s: cannot read directory %s: %v", key, cat.dir, err))
+		}
+	}
+
+	for _, prefix := range []string{"QUOTES", "JOKES"} {
+		key := prefix + "_FILE"
+		paths := splitPaths(os.Getenv(key))
+		if len(paths) == 0 {
+			problems = append(problems, fmt.Sprintf("%s is not set", key))
+			continue
+		}
+		if _, err := loadLinesFromFiles(paths, os.Getenv(prefix+"_KEY")); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+	return problems
+}
+
+// envInt parses an integer from key, falling back to def when unset or invalid.
+func envInt(key string, def int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		fmt.Printf("Invalid %s %q, using %d: %v\n", key, raw, def, err)
+		return def
+	}
+	return n
+}
+
+// envDuration parses a Go duration (e.g. "10s") from key, falling back to def
+// when unset or invalid.
+func envDuration(key string, def time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		fmt.Printf("Invalid %s %q, using %s: %v\n", key, raw, def, err)
+		return def
+	}
+	return d
+}
+
+// corsConfig builds the CORS middleware config from CORS_ORIGINS,
+// CORS_METHODS, and CORS_ALLOW_CREDENTIALS.
+func corsConfig() cors.Config {
+	cfg := cors.ConfigDefault
+	if origins := os.Getenv("CORS_ORIGINS"); origins != "" {
+		cfg.AllowOrigins = origins
+	}
+	if methods := os.Getenv("CORS_METHODS"); methods != "" {
+		cfg.AllowMethods = methods
+	}
+	// Let browser clients read which image they were served.
+	cfg.ExposeHeaders = "X-Image-Name,X-Image-Number"
+	cfg.AllowCredentials = os.Getenv("CORS_ALLOW_CREDENTIALS") == "true"
+	if cfg.AllowCredentials && cfg.AllowOrigins == "*" {
+		fmt.Println("CORS_ALLOW_CREDENTIALS requires explicit CORS_ORIGINS, ignoring it")
+		cfg.AllowCredentials = false
+	}
+	return cfg
+}
+
+// compressLevel maps COMPRESS_LEVEL (disabled, speed, default, best) to the
+// compress middleware level.
+func compressLevel() compress.Level {
+	switch raw := os.Getenv("COMPRESS_LEVEL"); raw {
+	case "", "default":
+		return compress.LevelDefault
+	case "disabled":
+		return compress.LevelDisabled
+	case "speed":
+		return compress.LevelBestSpeed
+	case "best":
+		return compress.LevelBestCompression
+	default:
+		fmt.Printf("Invalid COMPRESS_LEVEL %q, using default\n", raw)
+		return compress.LevelDefault
+	}
+}
+
+// numberStrategyFromEnv reads NUMBER_STRATEGY, falling back to the last digit
+// run when it is unset or invalid.
+func numberStrategyFromEnv() string {
+	switch raw := os.Getenv("NUMBER_STRATEGY"); raw {
+	case "":
+		return numberStrategyLast
+	case numberStrategyFirst, numberStrategyLast, numberStrategyLargest:
+		return raw
+	default:
+		fmt.Printf("Invalid NUMBER_STRATEGY %q, using %s\n", raw, numberStrategyLast)
+		return numberStrategyLast
+	}
+}
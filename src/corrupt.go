@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const maxCorruptRerolls = 3
+
+// validateOnServe checks a random pick's image header before serving it and
+// re-rolls past files that don't decode. It follows WARM_DIMENSIONS, since
+// the header decode is then cached for every good file anyway.
+var validateOnServe bool
+
+// decodableExtensions are the formats with a registered image decoder; other
+// IMAGE_EXTENSIONS are served unchecked.
+var decodableExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true}
+
+// pickServableFileName is pickWeightedFileName that, with validateOnServe,
+// skips up to maxCorruptRerolls images whose header fails to decode, logging
+// and counting each one. pool and weights are snapshots, so callers release
+// imageCacheMu first: the header decode goes to disk, through readStorage.
+func pickServableFileName(cat *Category, pool []string, weights map[string]int) string {
+	name := pickWeightedFileName(cat, pool, weights)
+	if !validateOnServe || len(pool) == 0 {
+		return name
+	}
+	for attempt := 0; attempt < maxCorruptRerolls; attempt++ {
+		if !decodableExtensions[strings.ToLower(filepath.Ext(name))] {
+			return name
+		}
+		_, err := cat.imageDimensions(filepath.Join(cat.imageDir(), name))
+		// A missing file is not corrupt; sendImageFile rescans for it. Nor is
+		// one on failing storage, which sendImageFile answers with 503.
+		if err == nil || errors.Is(err, fs.ErrNotExist) || isStorageError(err) {
+			return name
+		}
+		fmt.Printf("[%s] Skipping corrupt image: %v\n", cat.label, err)
+		recordCorruptSkipped(cat)
+		pool = slices.DeleteFunc(slices.Clone(pool), func(n string) bool { return n == name })
+		if len(pool) == 0 {
+			return name
+		}
+		name = pickWeightedFileName(cat, pool, weights)
+	}
+	return name
+}
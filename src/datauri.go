@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultDataURIMaxBytes = 1 << 20
+
+// dataURIMaxBytes caps the file size ?encoding=base64 will inline
+// (DATA_URI_MAX_BYTES). Base64 adds a third on top.
+var dataURIMaxBytes int64 = defaultDataURIMaxBytes
+
+// sendDataURI answers with {"data_uri": "data:<mime>;base64,..."} for path,
+// sniffing the MIME type from the content and falling back to the extension.
+func sendDataURI(c *fiber.Ctx, cat *Category, path string) error {
+	info, err := cat.statImage(path)
+	if err != nil && cat.breaker != nil && isStorageError(err) {
+		fmt.Printf("[%s] Failed to read %s: %v\n", cat.label, path, err)
+		return sendStorageUnavailable(c)
+	}
+	if err != nil || !info.Mode().IsRegular() {
+		return cat.imageGone(c, path)
+	}
+	if info.Size() > dataURIMaxBytes {
+		return sendError(c, fiber.StatusRequestEntityTooLarge, fmt.Sprintf("image exceeds the %d byte data URI limit", dataURIMaxBytes))
+	}
+	data, err := cat.readImage(path)
+	if err != nil && cat.breaker != nil && isStorageError(err) {
+		fmt.Printf("[%s] Failed to read %s: %v\n", cat.label, path, err)
+		return sendStorageUnavailable(c)
+	}
+	if err != nil {
+		fmt.Printf("[%s] Failed to read %s: %v\n", cat.label, path, err)
+		return sendError(c, fiber.StatusInternalServerError, "could not read image")
+	}
+
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		if byExt := mime.TypeByExtension(strings.ToLower(filepath.Ext(path))); byExt != "" {
+			contentType = byExt
+		}
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"data_uri": "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data),
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+)
+
+// dedupImages collapses byte-identical images so each one is in the random
+// selection pool once (DEDUP). Lookups by number or name still see every
+// file.
+var dedupImages bool
+
+// dedupByContent returns images with every duplicate after the first copy
+// removed, keeping a default image over its copies. Files that can't be
+// hashed are kept. Hashes come from the shared hash cache, so a rescan only
+// reads new or changed files.
+func dedupByContent(cat *Category, dir string, images []string) []string {
+	sums := make(map[string]string, len(images))
+	keep := make(map[string]string, len(images))
+	duplicates := 0
+	for _, name := range images {
+		sum, err := getFileSHA256(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		sums[name] = sum
+		first, seen := keep[sum]
+		if !seen {
+			keep[sum] = name
+			continue
+		}
+		duplicates++
+		if slices.Contains(cat.defaultImages, name) && !slices.Contains(cat.defaultImages, first) {
+			keep[sum] = name
+		}
+	}
+	if duplicates == 0 {
+		return images
+	}
+
+	fmt.Printf("[%s] Found %d duplicate images, each is picked once\n", cat.label, duplicates)
+	return slices.DeleteFunc(slices.Clone(images), func(name string) bool {
+		sum, hashed := sums[name]
+		return hashed && keep[sum] != name
+	})
+}
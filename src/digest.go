@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// serveDigestHandler bundles a random line from each line file and a random
+// image URL from each category into one response. ?include=quote,gary limits
+// it to the named parts; by default everything is included.
+func serveDigestHandler(categories []*Category, lineFiles map[string]*LineFile) fiber.Handler {
+	byName := make(map[string]*Category, len(categories))
+	var all []string
+	for name := range lineFiles {
+		all = append(all, name)
+	}
+	for _, cat := range categories {
+		byName[cat.name] = cat
+		all = append(all, cat.name)
+	}
+
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, randomCacheControl)
+		include := all
+		if raw := c.Query("include"); raw != "" {
+			include = nil
+			for _, part := range strings.Split(raw, ",") {
+				part = strings.ToLower(strings.TrimSpace(part))
+				if part == "" {
+					continue
+				}
+				if lineFiles[part] == nil && byName[part] == nil {
+					return sendError(c, fiber.StatusBadRequest, fmt.Sprintf("unknown digest part %s", part))
+				}
+				include = append(include, part)
+			}
+		}
+
+		resp := fiber.Map{}
+		for _, part := range include {
+			if lf, ok := lineFiles[part]; ok {
+				line, err := lf.random()
+				if err != nil {
+					return sendError(c, fiber.StatusInternalServerError, err.Error())
+				}
+				resp[part] = line
+				continue
+			}
+
+			cat := byName[part]
+			imageCacheMu.RLock()
+			imageName := pickRandomFileName(cat, cat.pool)
+			imageCacheMu.RUnlock()
+			if imageName == "" {
+				return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no images in %s", cat.name))
+			}
+			recordImageServed(cat)
+			resp[part] = imageURLPayload(cat, imageName)
+		}
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// endpointNames are the route groups DISABLED_ENDPOINTS can switch off.
+// Category names apply to every category, so "thumb" drops /gary/image/:id/thumb
+// along with the goober and gully ones. Health checks can't be disabled.
+var endpointNames = []string{
+	"upload", "thumb", "meta", "list", "manifest", "tags", "daily", "for",
+	"updated", "events", "stream", "ws", "mosaic", "reset",
+	"random", "categories", "quote", "joke", "digest",
+	"info", "stats", "metrics", "openapi", "admin",
+}
+
+// disabledEndpoints is the DISABLED_ENDPOINTS set. Disabled routes are never
+// registered, so they answer 404 like any unknown path.
+var disabledEndpoints map[string]bool
+
+// parseDisabledEndpoints turns a comma-separated list like "joke,upload" into
+// a lookup set, warning about names that match no endpoint.
+func parseDisabledEndpoints(raw string) map[string]bool {
+	disabled := map[string]bool{}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(endpointNames, name) {
+			fmt.Printf("Unknown endpoint %q in DISABLED_ENDPOINTS (known: %s)\n", name, strings.Join(endpointNames, ","))
+			continue
+		}
+		disabled[name] = true
+	}
+	return disabled
+}
+
+func endpointEnabled(name string) bool {
+	return !disabledEndpoints[name]
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+)
+
+// errorCodes are the stable, machine-readable codes in the error envelope.
+// Statuses not listed fall back to "error".
+var errorCodes = map[int]string{
+	fiber.StatusBadRequest:                   "bad_request",
+	fiber.StatusUnauthorized:                 "unauthorized",
+	fiber.StatusForbidden:                    "forbidden",
+	fiber.StatusNotFound:                     "not_found",
+	fiber.StatusMethodNotAllowed:             "method_not_allowed",
+	fiber.StatusConflict:                     "conflict",
+	fiber.StatusRequestEntityTooLarge:        "payload_too_large",
+	fiber.StatusUnsupportedMediaType:         "unsupported_media_type",
+	fiber.StatusRequestedRangeNotSatisfiable: "range_not_satisfiable",
+	fiber.StatusUnprocessableEntity:          "unprocessable_entity",
+	fiber.StatusUpgradeRequired:              "upgrade_required",
+	fiber.StatusTooManyRequests:              "rate_limited",
+	fiber.StatusInternalServerError:          "internal_error",
+	fiber.StatusServiceUnavailable:           "unavailable",
+}
+
+// retryAfterSeconds is sent as Retry-After on every 503 (RETRY_AFTER_SECONDS).
+var retryAfterSeconds = 5
+
+// setRetryAfter asks clients to back off before retrying a 503.
+func setRetryAfter(c *fiber.Ctx) {
+	if retryAfterSeconds > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfterSeconds))
+	}
+}
+
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	return "error"
+}
+
+// requestID returns the ID assigned by the requestid middleware, or "" when
+// it has not run.
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(requestid.ConfigDefault.ContextKey).(string)
+	return id
+}
+
+// sendError writes the standard error envelope,
+// {"ok": false, "error": {"code", "message"}}, with the code derived from
+// status. The request ID is included so a failed response can be matched to
+// its log line, and a 503 gets Retry-After. Success payloads are not wrapped.
+func sendError(c *fiber.Ctx, status int, message string) error {
+	return sendErrorBody(c, status, fiber.Map{"code": errorCode(status), "message": message})
+}
+
+// sendFieldErrors is sendError with a per-field breakdown under
+// "error.fields", e.g. {"text": "must not be empty"}, for request bodies that
+// fail validation.
+func sendFieldErrors(c *fiber.Ctx, status int, message string, fields map[string]string) error {
+	return sendErrorBody(c, status, fiber.Map{"code": errorCode(status), "message": message, "fields": fields})
+}
+
+func sendErrorBody(c *fiber.Ctx, status int, errBody fiber.Map) error {
+	body := fiber.Map{
+		"ok":    false,
+		"error": errBody,
+	}
+	if id := requestID(c); id != "" {
+		body["request_id"] = id
+	}
+	if status == fiber.StatusServiceUnavailable {
+		setRetryAfter(c)
+	}
+	return c.Status(status).JSON(body)
+}
+
+// handleError is the app's ErrorHandler, so Fiber's own errors (unknown
+// routes, recovered panics) use the same envelope as the handlers.
+func handleError(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return sendError(c, fiberErr.Code, fiberErr.Message)
+	}
+	fmt.Printf("Unhandled error on %s %s: %v\n", c.Method(), c.Path(), err)
+	return sendError(c, fiber.StatusInternalServerError, "internal server error")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultWatcherEventCount = 50
+
+// watcherEventCount is how many events each category keeps (WATCHER_EVENTS).
+var watcherEventCount = defaultWatcherEventCount
+
+// watchEvent is one fsnotify event as seen by a category watcher.
+type watchEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Op        string    `json:"op"`
+	Filename  string    `json:"filename"`
+}
+
+// eventLog is a fixed-size ring buffer of the most recent watcher events.
+type eventLog struct {
+	mu          sync.Mutex
+	events      []watchEvent
+	next        int
+	full        bool
+	subscribers map[chan watchEvent]struct{}
+}
+
+func newEventLog(size int) *eventLog {
+	if size <= 0 {
+		size = defaultWatcherEventCount
+	}
+	return &eventLog{events: make([]watchEvent, size)}
+}
+
+func (l *eventLog) add(event watchEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events[l.next] = event
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+	for ch := range l.subscribers {
+		// A subscriber that isn't keeping up misses events rather than
+		// stalling the watcher.
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// recent returns the logged events, newest first.
+func (l *eventLog) recent() []watchEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := l.next
+	if l.full {
+		n = len(l.events)
+	}
+	out := make([]watchEvent, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, l.events[(l.next-i+len(l.events))%len(l.events)])
+	}
+	return out
+}
+
+// serveEventsHandler lists cat's recent watcher events, e.g. to confirm a
+// freshly copied file was picked up.
+func serveEventsHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"events": cat.events.recent()})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// fallbackImages is a small baseline set per built-in category, used with
+// EMBEDDED_FALLBACK=true so the API can run without any image directories.
+//
+//go:embed assets/fallback
+var fallbackImages embed.FS
+
+var embeddedFallback bool
+
+// unpackEmbeddedFallback unpacks cat's embedded images for rescan to serve
+// while its directory, and its <NAME>_DIR_FALLBACK, are unset, unreadable,
+// or empty. A real directory with content always wins.
+//
+// Every handler works on paths (SendFile, thumbnails, hashes), so the set is
+// copied to a temporary directory instead of being served from the embed.FS.
+func (cat *Category) unpackEmbeddedFallback() {
+	set, ok := embeddedFallbackSet(cat.name)
+	if !ok {
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "garyapi-"+cat.name+"-")
+	if err != nil {
+		fmt.Printf("[%s] Could not unpack the embedded fallback images: %v\n", cat.label, err)
+		return
+	}
+	if err := os.CopyFS(dir, set); err != nil {
+		fmt.Printf("[%s] Could not unpack the embedded fallback images: %v\n", cat.label, err)
+		os.RemoveAll(dir)
+		return
+	}
+	cat.embeddedDir = dir
+}
+
+// embeddedFallbackSet returns the embedded images for the category name,
+// reporting false when EMBEDDED_FALLBACK is off or there are none.
+func embeddedFallbackSet(name string) (fs.FS, bool) {
+	if !embeddedFallback {
+		return nil, false
+	}
+	set, err := fs.Sub(fallbackImages, "assets/fallback/"+name)
+	if err != nil {
+		return nil, false
+	}
+	if entries, err := fs.ReadDir(set, "."); err != nil || len(entries) == 0 {
+		return nil, false
+	}
+	return set, true
+}
+
+// removeEmbeddedFallbacks deletes the temporary directories created by
+// unpackEmbeddedFallback.
+func removeEmbeddedFallbacks(categories []*Category) {
+	for _, cat := range categories {
+		if cat.embeddedDir != "" {
+			os.RemoveAll(cat.embeddedDir)
+		}
+	}
+}
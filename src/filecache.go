@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	dimensionCache = map[string]imageDimensions{}
+	// dimensionFailures remembers headers that didn't decode, so a corrupt
+	// file isn't re-read on every request. Storage errors aren't cached.
+	dimensionFailures = map[string]error{}
+	dimensionCacheMu  sync.RWMutex
+
+	hashCache   = map[string]string{}
+	hashCacheMu sync.RWMutex
+
+	// maxDecodePixels caps the width*height of images decoded in full for
+	// thumbnails, mosaic cells, and WebP transcodes (MAX_DECODE_PIXELS), so
+	// a small file with a huge canvas can't exhaust memory. 0 disables it.
+	maxDecodePixels = 50_000_000
+)
+
+type imageDimensions struct {
+	width  int
+	height int
+}
+
+// getImageDimensions decodes only the image header and caches the result,
+// or the decode failure, by path.
+func getImageDimensions(path string) (imageDimensions, error) {
+	dimensionCacheMu.RLock()
+	dims, ok := dimensionCache[path]
+	failure := dimensionFailures[path]
+	dimensionCacheMu.RUnlock()
+	if ok {
+		return dims, nil
+	}
+	if failure != nil {
+		return imageDimensions{}, failure
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return imageDimensions{}, err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		err = fmt.Errorf("could not decode image header %s: %w", path, err)
+		if !isStorageError(err) {
+			dimensionCacheMu.Lock()
+			dimensionFailures[path] = err
+			dimensionCacheMu.Unlock()
+		}
+		return imageDimensions{}, err
+	}
+
+	dims = imageDimensions{width: cfg.Width, height: cfg.Height}
+	dimensionCacheMu.Lock()
+	dimensionCache[path] = dims
+	dimensionCacheMu.Unlock()
+	return dims, nil
+}
+
+// decodeImage fully decodes the image at path, refusing it from the header
+// alone when it is over maxDecodePixels. The file is read through
+// readStorage and decoded from memory, so only the disk is timed.
+func (cat *Category) decodeImage(path string) (image.Image, string, error) {
+	dims, err := cat.imageDimensions(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if maxDecodePixels > 0 && int64(dims.width)*int64(dims.height) > int64(maxDecodePixels) {
+		return nil, "", fmt.Errorf("%s is %dx%d, over the %d pixel decode limit", path, dims.width, dims.height, maxDecodePixels)
+	}
+	data, err := cat.readImage(path)
+	if err != nil {
+		return nil, "", err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("could not decode %s: %w", path, err)
+	}
+	return img, format, nil
+}
+
+// getFileSHA256 returns the hex SHA-256 of the file contents. It is computed
+// on first use and cached by path.
+func getFileSHA256(path string) (string, error) {
+	hashCacheMu.RLock()
+	sum, ok := hashCache[path]
+	hashCacheMu.RUnlock()
+	if ok {
+		return sum, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("could not hash %s: %w", path, err)
+	}
+
+	sum = hex.EncodeToString(h.Sum(nil))
+	hashCacheMu.Lock()
+	hashCache[path] = sum
+	hashCacheMu.Unlock()
+	return sum, nil
+}
+
+// getFileETag returns a strong ETag built from the file's SHA-256.
+func getFileETag(path string) (string, error) {
+	sum, err := getFileSHA256(path)
+	if err != nil {
+		return "", err
+	}
+	return `"` + sum[:32] + `"`, nil
+}
+
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// applyETag sets the ETag header for path and reports whether the client's
+// If-None-Match already matches it, in which case a 304 should be sent. The
+// hash is read through readStorage.
+func (cat *Category) applyETag(c *fiber.Ctx, path string) bool {
+	etag, err := readStorage(cat, func() (string, error) { return getFileETag(path) })
+	if err != nil {
+		return false
+	}
+	c.Set(fiber.HeaderETag, etag)
+	ifNoneMatch := c.Get(fiber.HeaderIfNoneMatch)
+	return ifNoneMatch != "" && etagMatches(ifNoneMatch, etag)
+}
+
+// staticName is the slash-separated path a request names under mount.
+func staticName(c *fiber.Ctx, mount string) (string, bool) {
+	path := c.Path()
+	if len(path) < len(mount) {
+		return "", false
+	}
+	name := strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+path[len(mount):])), "/")
+	return name, name != ""
+}
+
+// staticFilePath maps name to the regular file it would serve from
+// cat.imageDir(). err is only set when the stat itself failed.
+func (cat *Category) staticFilePath(name string) (string, bool, error) {
+	fullPath := filepath.Join(cat.imageDir(), name)
+	info, err := cat.statImage(fullPath)
+	switch {
+	case isStorageError(err):
+		return "", false, err
+	case err != nil || !info.Mode().IsRegular():
+		return "", false, nil
+	}
+	return fullPath, true, nil
+}
+
+// serveStaticFiles serves cat's static mount from cat.imageDir() with
+// Cache-Control and ETag handling, behind the same circuit breaker and read
+// slots as the image routes. Routes are case-insensitive, so /gary/... also
+// reaches it; requests that don't resolve to a file are passed through. While
+// the breaker is open nothing is statted, so only listed images get 503.
+func serveStaticFiles(cat *Category, mount string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead {
+			return c.Next()
+		}
+		name, ok := staticName(c, mount)
+		if !ok {
+			return c.Next()
+		}
+		probe, ok := cat.breaker.allow()
+		if !ok {
+			if cat.hasImage(name) {
+				return sendStorageFailing(c, cat)
+			}
+			return c.Next()
+		}
+		fullPath, ok, err := cat.staticFilePath(name)
+		if err != nil && cat.breaker != nil {
+			cat.breaker.release(probe)
+			fmt.Printf("[%s] Failed to read %s: %v\n", cat.label, name, err)
+			return sendStorageFailing(c, cat)
+		}
+		if !ok {
+			// Not a file, so likely an API route: leave the probe to it.
+			cat.breaker.release(probe)
+			return c.Next()
+		}
+		defer cat.breaker.release(probe)
+		return cat.withReadSlot(c, func(c *fiber.Ctx) error {
+			c.Set(fiber.HeaderCacheControl, imageCacheControl)
+			if cat.applyETag(c, fullPath) {
+				return c.SendStatus(fiber.StatusNotModified)
+			}
+			return sendImageFile(c, cat, fullPath)
+		})
+	}
+}
+
+func (cat *Category) hasImage(name string) bool {
+	imageCacheMu.RLock()
+	defer imageCacheMu.RUnlock()
+	return slices.Contains(cat.images, name)
+}
+
+func invalidateFileCaches(path string) {
+	dimensionCacheMu.Lock()
+	delete(dimensionCache, path)
+	delete(dimensionFailures, path)
+	dimensionCacheMu.Unlock()
+
+	hashCacheMu.Lock()
+	delete(hashCache, path)
+	hashCacheMu.Unlock()
+
+	invalidateWebPCache(path)
+	invalidateThumbnails(path)
+	invalidateMosaicCell(path)
+}
+
+// clearFileCaches drops every cached dimension, hash, WebP, thumbnail, and
+// mosaic cell.
+func clearFileCaches() {
+	dimensionCacheMu.Lock()
+	clear(dimensionCache)
+	clear(dimensionFailures)
+	dimensionCacheMu.Unlock()
+
+	hashCacheMu.Lock()
+	clear(hashCache)
+	hashCacheMu.Unlock()
+
+	webpCache.clear()
+
+	thumbCacheMu.Lock()
+	clear(thumbCache)
+	thumbCacheMu.Unlock()
+
+	mosaicCells.clear()
+}
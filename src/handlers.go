@@ -0,0 +1,583 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// sendImageFile streams path and sets Content-Type from its extension, so
+// formats like .webp and .gif are labelled correctly regardless of sniffing.
+// A file that vanished since cat's list was built gets a JSON 404 and a rescan.
+func sendImageFile(c *fiber.Ctx, cat *Category, path string) error {
+	info, err := cat.statImage(path)
+	if err != nil && cat.breaker != nil && isStorageError(err) {
+		fmt.Printf("[%s] Failed to read %s: %v\n", cat.label, path, err)
+		return sendStorageUnavailable(c)
+	}
+	if err != nil || !info.Mode().IsRegular() {
+		return cat.imageGone(c, path)
+	}
+	if err := c.SendFile(path); err != nil {
+		cat.breaker.failure(err)
+		return err
+	}
+	cat.breaker.success()
+	if contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(path))); contentType != "" && c.Response().StatusCode() < fiber.StatusMultipleChoices {
+		c.Set(fiber.HeaderContentType, contentType)
+	}
+	return nil
+}
+
+// imageGone answers for a cached image that is no longer on disk, e.g. one
+// deleted between the watcher event and the rescan, and refreshes cat's list
+// so the next pick won't hit it.
+func (cat *Category) imageGone(c *fiber.Ctx, path string) error {
+	fmt.Printf("[%s] %s disappeared from disk, rescanning\n", cat.label, path)
+	invalidateFileCaches(path)
+	cat.rescan()
+	c.Response().Header.Del("X-Image-Name")
+	c.Response().Header.Del("X-Image-Number")
+	c.Response().Header.Del(fiber.HeaderETag)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return sendError(c, fiber.StatusNotFound, "image no longer exists, try again")
+}
+
+func serveStaticMissingHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name, err := url.PathUnescape(c.Params("*"))
+		if err != nil {
+			return c.Next()
+		}
+		imageCacheMu.RLock()
+		cached := slices.Contains(cat.images, name)
+		imageCacheMu.RUnlock()
+		if !cached {
+			return c.Next()
+		}
+		return cat.imageGone(c, filepath.Join(cat.imageDir(), name))
+	}
+}
+
+// sendImageBytes writes an in-memory image (thumbnail, WebP transcode) and,
+// like SendFile, answers a single Range request with 206 Partial Content.
+func sendImageBytes(c *fiber.Ctx, data []byte, contentType string) error {
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	byteRange := c.Get(fiber.HeaderRange)
+	if byteRange == "" {
+		c.Set(fiber.HeaderContentType, contentType)
+		return c.Status(fiber.StatusOK).Send(data)
+	}
+	start, end, err := fasthttp.ParseByteRange([]byte(byteRange), len(data))
+	if err != nil {
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", len(data)))
+		return c.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+	return c.Status(fiber.StatusPartialContent).Send(data[start : end+1])
+}
+
+// setImageHeaders names the streamed file so clients can tell which image
+// they got without a second request.
+func setImageHeaders(c *fiber.Ctx, imageName string) {
+	c.Set("X-Image-Name", imageName)
+	c.Set("X-Image-Number", strconv.Itoa(extractNumberFromFilename(imageName)))
+}
+
+func serveRandomImageHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, randomCacheControl)
+		pool, filterErr := filterPool(c, cat)
+		if filterErr != nil {
+			return sendError(c, filterErr.Code, filterErr.Message)
+		}
+		imageCacheMu.RLock()
+		weights, biasErr := selectionWeights(c, cat, pool)
+		if biasErr != nil {
+			imageCacheMu.RUnlock()
+			return sendError(c, biasErr.Code, biasErr.Message)
+		}
+		imageCacheMu.RUnlock()
+		imageName := pickServableFileName(cat, pool, weights)
+		if imageName == "" {
+			// Image clients still get something renderable, as when the
+			// default file is missing.
+			if c.Query("format") == "json" {
+				return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no images in %s", cat.name))
+			}
+			fmt.Printf("[%s] No images and no default configured, serving placeholder\n", cat.label)
+			return sendPlaceholder(c)
+		}
+		recordImageServed(cat)
+
+		if c.Query("format") == "json" {
+			return sendImageURLPayload(c, cat, imageName)
+		}
+		// Without a public base URL there is nowhere to redirect to, so stream.
+		if c.Query("mode") == "redirect" && cat.baseURL != "" {
+			return c.Redirect(buildImageURL(cat.baseURL, imageName), fiber.StatusFound)
+		}
+
+		fullPath := filepath.Join(cat.imageDir(), imageName)
+		if len(pool) == 0 {
+			if info, err := cat.statImage(fullPath); err != nil || !info.Mode().IsRegular() {
+				fmt.Printf("[%s] No images and default %s is missing, serving placeholder\n", cat.label, imageName)
+				return sendPlaceholder(c)
+			}
+		}
+		setImageHeaders(c, imageName)
+		// /image.:ext promises that extension, so it is never transcoded.
+		if webpTranscode && c.Query("format") == "webp" && c.Params("ext") == "" {
+			return sendWebP(c, cat, fullPath)
+		}
+		return sendImageFile(c, cat, fullPath)
+	}
+}
+
+func serveImageByNumberHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		number, err := c.ParamsInt("id")
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, "invalid image number")
+		}
+
+		imageCacheMu.RLock()
+		imageName, ok := findImageByNumber(cat.images, number)
+		if !ok && c.QueryBool("nearest") {
+			imageName, ok = findNearestImageByNumber(cat.images, number)
+		}
+		imageCacheMu.RUnlock()
+		if !ok {
+			return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no image with number %d", number))
+		}
+		recordImageServed(cat)
+
+		fullPath := filepath.Join(cat.imageDir(), imageName)
+		c.Set(fiber.HeaderCacheControl, imageCacheControl)
+		setImageHeaders(c, imageName)
+		switch c.Query("encoding") {
+		case "":
+		case "base64":
+			return sendDataURI(c, cat, fullPath)
+		default:
+			return sendError(c, fiber.StatusBadRequest, "encoding must be base64")
+		}
+		if cat.applyETag(c, fullPath) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		return sendImageFile(c, cat, fullPath)
+	}
+}
+
+// serveEndImageHandler streams the image with the smallest number, or the
+// largest when last is set, e.g. the newest upload from a numbering uploader.
+func serveEndImageHandler(cat *Category, last bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		imageCacheMu.RLock()
+		sorted := sortedByNumber(cat.images)
+		imageCacheMu.RUnlock()
+		if len(sorted) == 0 {
+			return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no images in %s", cat.name))
+		}
+		imageName := sorted[0]
+		if last {
+			imageName = sorted[len(sorted)-1]
+		}
+		recordImageServed(cat)
+
+		fullPath := filepath.Join(cat.imageDir(), imageName)
+		// The answer changes whenever an image is added, so cache it like a
+		// random pick rather than a fixed file.
+		c.Set(fiber.HeaderCacheControl, randomCacheControl)
+		setImageHeaders(c, imageName)
+		if cat.applyETag(c, fullPath) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		return sendImageFile(c, cat, fullPath)
+	}
+}
+
+// serveImageMetaHandler describes an image by number without streaming it.
+func serveImageMetaHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		number, err := c.ParamsInt("id")
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, "invalid image number")
+		}
+
+		imageCacheMu.RLock()
+		imageName, ok := findImageByNumber(cat.images, number)
+		imageCacheMu.RUnlock()
+		if !ok {
+			return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no image with number %d", number))
+		}
+
+		fullPath := filepath.Join(cat.imageDir(), imageName)
+		info, err := cat.statImage(fullPath)
+		if err != nil && cat.breaker != nil && isStorageError(err) {
+			return sendStorageUnavailable(c)
+		}
+		if err != nil {
+			return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no image with number %d", number))
+		}
+
+		resp := fiber.Map{
+			"filename":     imageName,
+			"number":       number,
+			"size":         info.Size(),
+			"modified":     info.ModTime().UTC().Format(time.RFC3339),
+			"content_type": mime.TypeByExtension(strings.ToLower(filepath.Ext(imageName))),
+		}
+		if dims, err := cat.imageDimensions(fullPath); err == nil {
+			resp["width"] = dims.width
+			resp["height"] = dims.height
+		}
+		c.Set(fiber.HeaderCacheControl, imageCacheControl)
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+}
+
+var errUnsafePath = errors.New("invalid image name")
+
+// resolveImagePath joins name onto dir, rejecting absolute paths, ".." segments,
+// and anything that would resolve outside of dir.
+func resolveImagePath(dir, name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", errUnsafePath
+	}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return "", errUnsafePath
+		}
+	}
+
+	cleanDir := filepath.Clean(dir)
+	fullPath := filepath.Clean(filepath.Join(cleanDir, name))
+	if !strings.HasPrefix(fullPath, cleanDir+string(os.PathSeparator)) {
+		return "", errUnsafePath
+	}
+	return fullPath, nil
+}
+
+func serveImageByNameHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name, err := url.PathUnescape(c.Params("*"))
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, errUnsafePath.Error())
+		}
+		fullPath, err := resolveImagePath(cat.imageDir(), name)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, err.Error())
+		}
+
+		imageCacheMu.RLock()
+		ok := slices.Contains(cat.images, name)
+		imageCacheMu.RUnlock()
+		if !ok {
+			return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no image named %s", name))
+		}
+		recordImageServed(cat)
+
+		c.Set(fiber.HeaderCacheControl, imageCacheControl)
+		setImageHeaders(c, name)
+		if cat.applyETag(c, fullPath) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		return sendImageFile(c, cat, fullPath)
+	}
+}
+
+func serveImageURLHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, randomCacheControl)
+		if c.Query("count") != "" {
+			return serveMultipleImageURLs(c, cat)
+		}
+
+		pool, filterErr := filterPool(c, cat)
+		if filterErr != nil {
+			return sendError(c, filterErr.Code, filterErr.Message)
+		}
+		imageCacheMu.RLock()
+		weights, biasErr := selectionWeights(c, cat, pool)
+		if biasErr != nil {
+			imageCacheMu.RUnlock()
+			return sendError(c, biasErr.Code, biasErr.Message)
+		}
+		imageCacheMu.RUnlock()
+		imageName := pickServableFileName(cat, pool, weights)
+		if imageName == "" {
+			return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no images in %s", cat.name))
+		}
+		recordImageServed(cat)
+
+		return sendImageURLPayload(c, cat, imageName)
+	}
+}
+
+func serveMultipleImageURLs(c *fiber.Ctx, cat *Category) error {
+	count := c.QueryInt("count")
+	if count < 1 || count > maxImageCount {
+		return sendError(c, fiber.StatusBadRequest, fmt.Sprintf("count must be between 1 and %d", maxImageCount))
+	}
+
+	pool, filterErr := filterPool(c, cat)
+	if filterErr != nil {
+		return sendError(c, filterErr.Code, filterErr.Message)
+	}
+	imageCacheMu.RLock()
+	picks := sampleImages(cat, pool, count, c.QueryBool("unique"))
+	imageCacheMu.RUnlock()
+	if len(picks) == 0 || picks[0] == "" {
+		return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no images in %s", cat.name))
+	}
+
+	images := make([]fiber.Map, 0, len(picks))
+	for _, name := range picks {
+		recordImageServed(cat)
+		images = append(images, imageURLPayload(cat, name))
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"images": images})
+}
+
+// serveSelectionResetHandler clears cat's no-repeat history, e.g. after a bulk
+// content change, so recently served images are eligible again.
+func serveSelectionResetHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cleared := cat.resetSelection()
+		fmt.Printf("[%s] Selection state reset (%d recent picks cleared)\n", cat.label, cleared)
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"reset": cat.name, "recent_cleared": cleared})
+	}
+}
+
+func serveImageCountHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		imageCacheMu.RLock()
+		count := len(cat.images)
+		imageCacheMu.RUnlock()
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"count": count})
+	}
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+func serveImageListHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		page := c.QueryInt("page", 1)
+		limit := c.QueryInt("limit", defaultListLimit)
+		if page < 1 || limit < 1 || limit > maxListLimit {
+			return sendError(c, fiber.StatusBadRequest, fmt.Sprintf("page must be at least 1 and limit between 1 and %d", maxListLimit))
+		}
+
+		imageCacheMu.RLock()
+		sorted := sortedByNumber(cat.images)
+		imageCacheMu.RUnlock()
+
+		total := len(sorted)
+		start := min((page-1)*limit, total)
+		end := min(start+limit, total)
+
+		images := make([]fiber.Map, 0, end-start)
+		for _, name := range sorted[start:end] {
+			images = append(images, fiber.Map{
+				"url":    buildImageURL(cat.baseURL, name),
+				"number": extractNumberFromFilename(name),
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"images":   images,
+			"total":    total,
+			"page":     page,
+			"limit":    limit,
+			"has_next": end < total,
+		})
+	}
+}
+
+func serveDailyImageHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		date := time.Now().UTC().Format(time.DateOnly)
+
+		imageCacheMu.RLock()
+		imageName := pickByKey(cat.pool, date, cat.defaultImages)
+		empty := len(cat.images) == 0
+		imageCacheMu.RUnlock()
+		if imageName == "" {
+			return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no images in %s", cat.name))
+		}
+		if empty {
+			recordDefaultServed(cat, imageName)
+		}
+		recordImageServed(cat)
+
+		resp := imageURLPayload(cat, imageName)
+		resp["date"] = date
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+}
+
+// serveKeyedImageHandler gives each key (a username, say) its own stable image
+// without storing a mapping.
+func serveKeyedImageHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key, err := url.PathUnescape(c.Params("key"))
+		if err != nil || key == "" {
+			return sendError(c, fiber.StatusBadRequest, "invalid key")
+		}
+
+		imageCacheMu.RLock()
+		imageName := pickByRendezvous(cat.pool, key, cat.defaultImages)
+		empty := len(cat.images) == 0
+		imageCacheMu.RUnlock()
+		if imageName == "" {
+			return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no images in %s", cat.name))
+		}
+		if empty {
+			recordDefaultServed(cat, imageName)
+		}
+		recordImageServed(cat)
+
+		resp := imageURLPayload(cat, imageName)
+		resp["key"] = key
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+}
+
+func serveTagsHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		imageCacheMu.RLock()
+		tags := distinctTags(cat.name, cat.images)
+		imageCacheMu.RUnlock()
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"tags": tags})
+	}
+}
+
+func serveRandomCategoryHandler(categories []*Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, randomCacheControl)
+		if len(categories) == 0 {
+			return sendError(c, fiber.StatusNotFound, "no categories configured")
+		}
+
+		imageCacheMu.RLock()
+		cat := pickCategory(categories, c.QueryBool("weighted"))
+		imageName := pickRandomFileName(cat, cat.pool)
+		imageCacheMu.RUnlock()
+		if imageName == "" {
+			return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no images in %s", cat.name))
+		}
+		recordImageServed(cat)
+
+		resp := imageURLPayload(cat, imageName)
+		resp["category"] = cat.name
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+}
+
+// serveCategoriesHandler lists the configured categories so clients can
+// discover them instead of hardcoding gary, goober, and gully.
+func serveCategoriesHandler(categories []*Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		imageCacheMu.RLock()
+		list := make([]fiber.Map, 0, len(categories))
+		for _, cat := range categories {
+			entry := fiber.Map{
+				"name":     cat.name,
+				"count":    len(cat.images),
+				"base_url": cat.baseURL,
+				"defaults": []string{},
+			}
+			if len(cat.defaultImages) > 0 {
+				entry["default"] = cat.defaultImages[0]
+				entry["defaults"] = cat.defaultImages
+			}
+			list = append(list, entry)
+		}
+		imageCacheMu.RUnlock()
+		return c.Status(fiber.StatusOK).JSON(list)
+	}
+}
+
+// negotiateImage hands the request to imageHandler when the Accept header
+// prefers an image over JSON, and to jsonHandler otherwise. Missing, */*, or
+// tied Accept headers get JSON, as do multi-image (?count=) requests.
+func negotiateImage(jsonHandler, imageHandler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Vary(fiber.HeaderAccept)
+		if c.Query("count") == "" && c.Accepts(fiber.MIMEApplicationJSON, "image/*") == "image/*" {
+			return imageHandler(c)
+		}
+		return jsonHandler(c)
+	}
+}
+
+// registerCategoryRoutes mounts every per-category route for cat. Get also
+// registers HEAD, so the image routes answer HEAD with headers only.
+func registerCategoryRoutes(router fiber.Router, cat *Category) {
+	static := "/" + cat.label
+	// The middleware sees the full request path, so match on the prefixed mount.
+	mount := routePrefix + static
+	router.Use(static, serveStaticFiles(cat, mount))
+
+	base := "/" + cat.name
+	router.Get(base+"/image", limitReads(cat, serveRandomImageHandler(cat)))
+	router.Get(base+"/image.:ext", limitReads(cat, serveRandomImageHandler(cat)))
+	if endpointEnabled("upload") {
+		router.Post(base+"/image", requireUploadToken, serveImageUploadHandler(cat))
+		router.Delete(base+"/image/:id<int>", requireUploadToken, serveImageDeleteHandler(cat))
+	}
+	if endpointEnabled("reset") {
+		router.Post(base+"/reset", requireAdminToken, serveSelectionResetHandler(cat))
+	}
+	router.Get(base+"/image/:id<int>", limitReads(cat, serveImageByNumberHandler(cat)))
+	if endpointEnabled("thumb") {
+		router.Get(base+"/image/:id<int>/thumb", limitReads(cat, serveThumbnailHandler(cat)))
+	}
+	router.Get(base+"/image/first", limitReads(cat, serveEndImageHandler(cat, false)))
+	router.Get(base+"/image/last", limitReads(cat, serveEndImageHandler(cat, true)))
+	router.Get(base+"/image/*", limitReads(cat, serveImageByNameHandler(cat)))
+	if mosaicEnabled && endpointEnabled("mosaic") {
+		router.Get(base+"/mosaic", limitReads(cat, serveMosaicHandler(cat)))
+	}
+	router.Get(base, limitReads(cat, negotiateImage(serveImageURLHandler(cat), serveRandomImageHandler(cat))))
+	router.Get(base+"/count", serveImageCountHandler(cat))
+
+	optional := []struct {
+		name, path string
+		handler    fiber.Handler
+	}{
+		{"meta", base + "/meta/:id<int>", serveImageMetaHandler(cat)},
+		{"list", base + "/list", serveImageListHandler(cat)},
+		{"manifest", base + "/manifest", serveManifestHandler(cat)},
+		{"tags", base + "/tags", serveTagsHandler(cat)},
+		{"daily", base + "/daily", serveDailyImageHandler(cat)},
+		{"for", base + "/for/:key", serveKeyedImageHandler(cat)},
+		{"updated", base + "/updated", serveUpdatedHandler(cat)},
+		{"events", base + "/events", serveEventsHandler(cat)},
+		{"stream", base + "/stream", serveStreamHandler(cat)},
+		{"ws", base + "/ws", serveImageSocketHandler(cat)},
+	}
+	for _, route := range optional {
+		if endpointEnabled(route.name) {
+			router.Get(route.path, route.handler)
+		}
+	}
+	// Registered last: it only answers for cached names that vanished from
+	// the static mount and otherwise falls through to the default 404.
+	router.Get(static+"/*", serveStaticMissingHandler(cat))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type healthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func checkResult(err error) healthCheck {
+	if err != nil {
+		return healthCheck{Status: "error", Error: err.Error()}
+	}
+	return healthCheck{Status: "ok"}
+}
+
+func checkDirReadable(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Readdirnames(1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// checkDefaultImages confirms cat's default images, the last-resort fallback,
+// exist. A missing default only warns while the category still has images;
+// with an empty directory as well, the category has nothing real left to
+// serve, so it fails.
+func checkDefaultImages(cat *Category) healthCheck {
+	var err error
+	if len(cat.defaultImages) == 0 {
+		err = errors.New("no default image configured")
+	}
+	for _, name := range cat.defaultImages {
+		if info, statErr := os.Stat(filepath.Join(cat.imageDir(), name)); statErr != nil || !info.Mode().IsRegular() {
+			err = fmt.Errorf("default image %s is missing", name)
+			break
+		}
+	}
+	if err == nil {
+		return healthCheck{Status: "ok"}
+	}
+
+	imageCacheMu.RLock()
+	empty := len(cat.images) == 0
+	imageCacheMu.RUnlock()
+	if empty {
+		return healthCheck{Status: "error", Error: err.Error() + " and the directory has no images"}
+	}
+	return healthCheck{Status: "warning", Error: err.Error()}
+}
+
+// checkCategoryDir checks cat's directory is readable. While the fallback
+// directory or the embedded set is serving, a failing or empty primary only
+// warns.
+func checkCategoryDir(cat *Category) healthCheck {
+	check := checkResult(checkDirReadable(cat.dir))
+	var msg string
+	switch cat.source.Load() {
+	case sourceFallback:
+		if err := checkDirReadable(cat.fallbackDir); err != nil {
+			return checkResult(err)
+		}
+		msg = fmt.Sprintf("serving from fallback %s", cat.fallbackDir)
+	case sourceEmbedded:
+		msg = "serving the embedded fallback set"
+	default:
+		return check
+	}
+	if check.Error != "" {
+		msg = check.Error + "; " + msg
+	}
+	return healthCheck{Status: "warning", Error: msg}
+}
+
+func checkFileReadable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// serveReadinessHandler verifies every image directory and line file is still
+// readable and every category can fall back to a default, answering 503 with
+// per-dependency details when one cannot, or while a category's circuit
+// breaker is open. Warnings alone, such as a category serving from its
+// fallback directory or a half-open breaker, report "degraded" with a 200.
+func serveReadinessHandler(categories []*Category, lineFiles map[string]*LineFile) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set("Cache-Control", "no-store")
+		healthy, degraded := true, false
+
+		dirs := make(map[string]healthCheck, len(categories))
+		for _, cat := range categories {
+			check := checkCategoryDir(cat)
+			healthy = healthy && check.Status != "error"
+			degraded = degraded || check.Status == "warning"
+			dirs[cat.name] = check
+		}
+
+		defaults := make(map[string]healthCheck, len(categories))
+		for _, cat := range categories {
+			check := checkDefaultImages(cat)
+			healthy = healthy && check.Status != "error"
+			degraded = degraded || check.Status == "warning"
+			defaults[cat.name] = check
+		}
+
+		var breakers map[string]breakerStatus
+		if breakerFailures > 0 {
+			breakers = make(map[string]breakerStatus, len(categories))
+			for _, cat := range categories {
+				status := cat.breaker.status()
+				healthy = healthy && status.State != breakerOpen
+				degraded = degraded || status.State == breakerHalfOpen
+				breakers[cat.name] = status
+			}
+		}
+
+		files := make(map[string]healthCheck, len(lineFiles))
+		for name, lf := range lineFiles {
+			check := checkResult(lf.checkReadable())
+			healthy = healthy && check.Status == "ok"
+			files[name] = check
+		}
+
+		status, code := "ok", fiber.StatusOK
+		if degraded {
+			status = "degraded"
+		}
+		if !healthy {
+			status, code = "unhealthy", fiber.StatusServiceUnavailable
+			setRetryAfter(c)
+		}
+		resp := fiber.Map{
+			"status":      status,
+			"directories": dirs,
+			"defaults":    defaults,
+			"files":       files,
+		}
+		if breakers != nil {
+			resp["breakers"] = breakers
+		}
+		return c.Status(code).JSON(resp)
+	}
+}
+
+func serveLivenessHandler(c *fiber.Ctx) error {
+	c.Set("Cache-Control", "no-store")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "ok",
+	})
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const maxRerolls = 16
+
+var (
+	noImmediateRepeat bool
+	// recentWindow keeps random picks from repeating any of a category's last
+	// N images (RECENT_WINDOW). 0 disables it.
+	recentWindow  int
+	maxImageCount = 50
+)
+
+// getRandomFileName picks uniformly from images, or proportionally to weights
+// when given. Images missing from weights count as 1; zero or negative
+// weights are never picked. An empty images falls back to a random entry of
+// defaults, or "" when there are none.
+func getRandomFileName(images []string, weights map[string]int, defaults []string) string {
+	if len(images) == 0 {
+		if len(defaults) == 0 {
+			return ""
+		}
+		return defaults[randIntN(len(defaults))]
+	}
+	if weights != nil {
+		total := 0
+		for _, name := range images {
+			total += imageWeight(weights, name)
+		}
+		if total > 0 {
+			n := randIntN(total)
+			for _, name := range images {
+				w := imageWeight(weights, name)
+				if n < w {
+					return name
+				}
+				n -= w
+			}
+		}
+	}
+	return images[randIntN(len(images))]
+}
+
+func imageWeight(weights map[string]int, name string) int {
+	w, ok := weights[name]
+	if !ok {
+		return 1
+	}
+	return max(w, 0)
+}
+
+// pickRandomFileName picks from pool (usually cat.pool or a filtered subset
+// of it) and, when NO_IMMEDIATE_REPEAT is enabled, re-rolls so a category
+// never returns the same image twice in a row. Callers must hold imageCacheMu
+// for reading.
+func pickRandomFileName(cat *Category, pool []string) string {
+	return pickWeightedFileName(cat, pool, cat.weights)
+}
+
+// pickWeightedFileName is pickRandomFileName with per-request weights in
+// place of cat.weights, e.g. from ?bias=recent.
+func pickWeightedFileName(cat *Category, pool []string, weights map[string]int) string {
+	if len(pool) == 0 {
+		name := getRandomFileName(nil, nil, cat.defaultImages)
+		if name != "" {
+			recordDefaultServed(cat, name)
+		}
+		return name
+	}
+	if recentWindow > 0 && len(pool) > 1 {
+		return pickAvoidingRecent(cat, pool, weights)
+	}
+	if !noImmediateRepeat || len(pool) < 2 {
+		return getRandomFileName(pool, weights, cat.defaultImages)
+	}
+
+	cat.pickMu.Lock()
+	defer cat.pickMu.Unlock()
+	name := getRandomFileName(pool, weights, cat.defaultImages)
+	// Bounded so weights that leave a single pickable image can't spin forever.
+	for attempt := 0; name == cat.lastPicked && attempt < maxRerolls; attempt++ {
+		name = getRandomFileName(pool, weights, cat.defaultImages)
+	}
+	cat.lastPicked = name
+	return name
+}
+
+// pickAvoidingRecent picks from pool minus the images cat served in its last
+// RECENT_WINDOW picks. The window shrinks to len(pool)-1 so there is always
+// something left to pick. Callers must hold imageCacheMu for reading.
+func pickAvoidingRecent(cat *Category, pool []string, weights map[string]int) string {
+	cat.pickMu.Lock()
+	defer cat.pickMu.Unlock()
+
+	window := min(recentWindow, len(pool)-1, len(cat.recent))
+	recent := make(map[string]bool, window)
+	for _, name := range cat.recent[len(cat.recent)-window:] {
+		recent[name] = true
+	}
+	fresh := make([]string, 0, len(pool))
+	for _, name := range pool {
+		if !recent[name] {
+			fresh = append(fresh, name)
+		}
+	}
+	if len(fresh) == 0 {
+		fresh = pool
+	}
+
+	name := getRandomFileName(fresh, weights, cat.defaultImages)
+	cat.recent = append(cat.recent, name)
+	if len(cat.recent) > recentWindow {
+		cat.recent = slices.Delete(cat.recent, 0, len(cat.recent)-recentWindow)
+	}
+	cat.lastPicked = name
+	return name
+}
+
+// resetSelection forgets cat's NO_IMMEDIATE_REPEAT and RECENT_WINDOW history,
+// returning how many recent picks were dropped.
+func (cat *Category) resetSelection() int {
+	cat.pickMu.Lock()
+	defer cat.pickMu.Unlock()
+	cleared := len(cat.recent)
+	cat.recent = nil
+	cat.lastPicked = ""
+	return cleared
+}
+
+// sampleImages returns count picks from pool. With unique set it samples
+// without replacement, so the result may be shorter than count. Callers must
+// hold imageCacheMu for reading.
+func sampleImages(cat *Category, pool []string, count int, unique bool) []string {
+	if !unique || len(pool) == 0 {
+		if unique {
+			count = 1
+		}
+		picks := make([]string, 0, count)
+		for range count {
+			picks = append(picks, pickRandomFileName(cat, pool))
+		}
+		return picks
+	}
+
+	picks := make([]string, 0, min(count, len(pool)))
+	for _, i := range randPerm(len(pool)) {
+		if len(picks) == count {
+			break
+		}
+		picks = append(picks, pool[i])
+	}
+	return picks
+}
+
+// filterPool narrows cat.pool by the request's selection params (the
+// :ext route param, ?tag=, ?orientation=, ?min=/?max=, and ?exclude=), then
+// applies the NIGHT_HOURS bias. It returns a 404 error when a filter matches nothing, or a
+// 409 when ?exclude= leaves nothing. It only holds imageCacheMu to take
+// cat.pool, since ?orientation= reads image headers, so callers must not
+// hold it.
+func filterPool(c *fiber.Ctx, cat *Category) ([]string, *fiber.Error) {
+	imageCacheMu.RLock()
+	pool := cat.pool
+	imageCacheMu.RUnlock()
+	if ext := c.Params("ext"); ext != "" {
+		pool = filterByExtension(pool, ext)
+		if len(pool) == 0 {
+			return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("no %s images with extension .%s", cat.name, ext))
+		}
+	}
+	if tag := c.Query("tag"); tag != "" {
+		pool = filterByTag(pool, tag)
+		if len(pool) == 0 {
+			return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("no %s images tagged %s", cat.name, tag))
+		}
+	}
+	if orientation := c.Query("orientation"); orientation != "" {
+		if !validOrientations[orientation] {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "orientation must be landscape, portrait, or square")
+		}
+		pool = filterByOrientation(cat, pool, orientation)
+		if len(pool) == 0 {
+			return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("no %s images with %s orientation", cat.name, orientation))
+		}
+	}
+	if c.Query("min") != "" || c.Query("max") != "" {
+		lo, hi, err := parseNumberRange(c.Query("min"), c.Query("max"))
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		pool = slices.DeleteFunc(slices.Clone(pool), func(name string) bool {
+			number := extractNumberFromFilename(name)
+			return number < lo || number > hi
+		})
+		if len(pool) == 0 {
+			return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("no %s images in the requested number range", cat.name))
+		}
+	}
+	if raw := c.Query("exclude"); raw != "" {
+		excluded, err := parseExcludedNumbers(raw)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		pool = slices.DeleteFunc(slices.Clone(pool), func(name string) bool {
+			return excluded[extractNumberFromFilename(name)]
+		})
+		if len(pool) == 0 {
+			return nil, fiber.NewError(fiber.StatusConflict, fmt.Sprintf("every matching %s image is excluded", cat.name))
+		}
+	}
+	return applyNightBias(pool, time.Now()), nil
+}
+
+// squareTolerance is how far apart width and height may be, relative to the
+// longer side, for an image to count as square.
+const squareTolerance = 0.05
+
+var validOrientations = map[string]bool{"landscape": true, "portrait": true, "square": true}
+
+// filterByOrientation keeps images of the given orientation using the cached
+// header dimensions, read through readStorage. Images whose header can't be
+// decoded are dropped.
+func filterByOrientation(cat *Category, images []string, orientation string) []string {
+	var matched []string
+	for _, name := range images {
+		dims, err := cat.imageDimensions(filepath.Join(cat.imageDir(), name))
+		if err != nil {
+			continue
+		}
+		if imageOrientation(dims) == orientation {
+			matched = append(matched, name)
+		}
+	}
+	return matched
+}
+
+func imageOrientation(dims imageDimensions) string {
+	longer := max(dims.width, dims.height)
+	if float64(distance(dims.width, dims.height)) <= squareTolerance*float64(longer) {
+		return "square"
+	}
+	if dims.width > dims.height {
+		return "landscape"
+	}
+	return "portrait"
+}
+
+// parseNumberRange reads the inclusive ?min= and ?max= bounds. Either may be
+// omitted to leave that side open.
+func parseNumberRange(rawMin, rawMax string) (int, int, error) {
+	lo, hi := math.MinInt, math.MaxInt
+	var err error
+	if rawMin != "" {
+		if lo, err = strconv.Atoi(rawMin); err != nil {
+			return 0, 0, fmt.Errorf("invalid min %q", rawMin)
+		}
+	}
+	if rawMax != "" {
+		if hi, err = strconv.Atoi(rawMax); err != nil {
+			return 0, 0, fmt.Errorf("invalid max %q", rawMax)
+		}
+	}
+	if lo > hi {
+		return 0, 0, fmt.Errorf("min %d is greater than max %d", lo, hi)
+	}
+	return lo, hi, nil
+}
+
+// parseExcludedNumbers reads a comma-separated ?exclude= list like "76,82".
+func parseExcludedNumbers(raw string) (map[int]bool, error) {
+	excluded := map[int]bool{}
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image number %q in exclude", part)
+		}
+		excluded[number] = true
+	}
+	return excluded, nil
+}
+
+// filterByExtension keeps images whose extension matches ext (with or without
+// the dot, case-insensitive). jpg and jpeg are treated as the same format.
+func filterByExtension(images []string, ext string) []string {
+	want := normalizeExtension("." + strings.TrimPrefix(ext, "."))
+	var matched []string
+	for _, name := range images {
+		if normalizeExtension(filepath.Ext(name)) == want {
+			matched = append(matched, name)
+		}
+	}
+	return matched
+}
+
+func normalizeExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	if ext == ".jpeg" {
+		return ".jpg"
+	}
+	return ext
+}
+
+// pickByKey maps key to a stable image via an FNV-1a hash, so the same key
+// gets the same image for as long as the image list is unchanged. An empty
+// images hashes into defaults instead.
+func pickByKey(images []string, key string, defaults []string) string {
+	if len(images) == 0 {
+		if len(defaults) == 0 {
+			return ""
+		}
+		return pickByKey(defaults, key, nil)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return images[h.Sum32()%uint32(len(images))]
+}
+
+// pickByRendezvous maps key to an image with rendezvous hashing: every image
+// is scored by a hash of key and its name and the highest score wins. Adding
+// or removing an image only moves the keys that land on it, unlike pickByKey.
+func pickByRendezvous(images []string, key string, defaults []string) string {
+	if len(images) == 0 {
+		if len(defaults) == 0 {
+			return ""
+		}
+		return pickByRendezvous(defaults, key, nil)
+	}
+	var best string
+	var bestScore uint64
+	for _, name := range images {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(name))
+		if score := h.Sum64(); best == "" || score > bestScore {
+			best, bestScore = name, score
+		}
+	}
+	return best
+}
+
+func findImageByNumber(images []string, number int) (string, bool) {
+	for _, name := range images {
+		if extractNumberFromFilename(name) == number {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// findNearestImageByNumber returns the image whose number is closest to
+// number, breaking ties toward the lower number.
+func findNearestImageByNumber(images []string, number int) (string, bool) {
+	best, bestNumber, found := "", 0, false
+	for _, name := range images {
+		n := extractNumberFromFilename(name)
+		if !found || distance(n, number) < distance(bestNumber, number) ||
+			(distance(n, number) == distance(bestNumber, number) && n < bestNumber) {
+			best, bestNumber, found = name, n, true
+		}
+	}
+	return best, found
+}
+
+func distance(a, b int) int {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// sortedByNumber returns a copy of images ordered by extracted number, then name.
+func sortedByNumber(images []string) []string {
+	sorted := slices.Clone(images)
+	slices.SortFunc(sorted, func(a, b string) int {
+		if na, nb := extractNumberFromFilename(a), extractNumberFromFilename(b); na != nb {
+			return na - nb
+		}
+		return strings.Compare(a, b)
+	})
+	return sorted
+}
+
+// Number strategies for NUMBER_STRATEGY, choosing which digit run of a file
+// name like Gary2024_76.jpg becomes its number.
+const (
+	numberStrategyFirst   = "first"
+	numberStrategyLast    = "last"
+	numberStrategyLargest = "largest"
+)
+
+var (
+	numberStrategy = numberStrategyLast
+	digitRuns      = regexp.MustCompile(`\d+`)
+)
+
+// extractNumberFromFilename reads a digit run of the base name, picked by
+// numberStrategy. The extension is ignored, and so are folders in a recursive
+// scan (e.g. 2024/Gary5.jpg).
+func extractNumberFromFilename(filename string) int {
+	base := path.Base(filename)
+	base = strings.TrimSuffix(base, path.Ext(base))
+	matches := digitRuns.FindAllString(base, -1)
+	if len(matches) == 0 {
+		return 0
+	}
+
+	numbers := make([]int, 0, len(matches))
+	for _, match := range matches {
+		var number int
+		fmt.Sscanf(match, "%d", &number)
+		numbers = append(numbers, number)
+	}
+	switch numberStrategy {
+	case numberStrategyFirst:
+		return numbers[0]
+	case numberStrategyLargest:
+		return slices.Max(numbers)
+	default:
+		return numbers[len(numbers)-1]
+	}
+}
+
+func buildImageURL(baseURL, imageName string) string {
+	cleanBaseURL := baseURL
+	if len(cleanBaseURL) > 0 && cleanBaseURL[len(cleanBaseURL)-1] == '/' {
+		cleanBaseURL = cleanBaseURL[:len(cleanBaseURL)-1]
+	}
+	return fmt.Sprintf("%s/%s", cleanBaseURL, imageName)
+}
+
+func imageURLPayload(cat *Category, imageName string) fiber.Map {
+	resp := fiber.Map{
+		"url":    buildImageURL(cat.baseURL, imageName),
+		"number": extractNumberFromFilename(imageName),
+	}
+	if dims, err := cat.imageDimensions(filepath.Join(cat.imageDir(), imageName)); err == nil {
+		resp["width"] = dims.width
+		resp["height"] = dims.height
+	}
+	return resp
+}
+
+func pickCategory(categories []*Category, weighted bool) *Category {
+	if weighted {
+		total := 0
+		for _, cat := range categories {
+			total += len(cat.images)
+		}
+		if total > 0 {
+			n := randIntN(total)
+			for _, cat := range categories {
+				if n < len(cat.images) {
+					return cat
+				}
+				n -= len(cat.images)
+			}
+		}
+	}
+	return categories[randIntN(len(categories))]
+}
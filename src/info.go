@@ -0,0 +1,73 @@
+package main
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Build metadata, set with -ldflags "-X main.version=... -X main.commit=...
+// -X main.buildTime=...". Local builds report "dev".
+var (
+	version   = "dev"
+	commit    = "dev"
+	buildTime = "dev"
+)
+
+// imageCounts returns the number of cached images per category and in total.
+func imageCounts(categories []*Category) (map[string]int, int) {
+	imageCacheMu.RLock()
+	defer imageCacheMu.RUnlock()
+
+	counts := make(map[string]int, len(categories))
+	total := 0
+	for _, cat := range categories {
+		counts[cat.name] = len(cat.images)
+		total += len(cat.images)
+	}
+	return counts, total
+}
+
+func serveInfoHandler(startTime time.Time, categories []*Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		handlerStart := time.Now()
+		c.Set("Cache-Control", "no-store")
+
+		now := time.Now().UTC()
+		uptime := now.Sub(startTime)
+		counts, total := imageCounts(categories)
+
+		resp := fiber.Map{
+			"version":       version,
+			"commit":        commit,
+			"build_time":    buildTime,
+			"now":           now.Format(time.RFC3339Nano),
+			"start_time":    startTime.Format(time.RFC3339Nano),
+			"uptime_ms":     uptime.Milliseconds(),
+			"go_version":    runtime.Version(),
+			"num_goroutine": runtime.NumGoroutine(),
+			"num_cpu":       runtime.NumCPU(),
+			"gomaxprocs":    runtime.GOMAXPROCS(0),
+			"images":        counts,
+			"images_total":  total,
+		}
+		updated := make(map[string]string, len(categories))
+		var latest time.Time
+		for _, cat := range categories {
+			if modTime := cat.contentStats().Updated; !modTime.IsZero() {
+				updated[cat.name] = modTime.Format(time.RFC3339)
+				if modTime.After(latest) {
+					latest = modTime
+				}
+			}
+		}
+		resp["updated"] = updated
+		if !latest.IsZero() {
+			resp["updated_latest"] = latest.Format(time.RFC3339)
+		}
+		resp["latencies"] = latencySnapshot()
+		resp["latency_ms"] = time.Since(handlerStart).Milliseconds()
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+}
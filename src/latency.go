@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+const defaultLatencySamples = 256
+
+// latencySamples is how many recent requests per route the /info latency
+// snapshot is computed over (LATENCY_SAMPLES).
+var latencySamples = defaultLatencySamples
+
+// latencyRing keeps the last latencySamples durations of one route.
+type latencyRing struct {
+	samples []time.Duration
+	next    int
+	count   int64
+}
+
+type latencySummary struct {
+	Count int64   `json:"count"`
+	AvgMs float64 `json:"avg_ms"`
+	P95Ms float64 `json:"p95_ms"`
+}
+
+var (
+	latencies   = map[string]*latencyRing{}
+	latenciesMu sync.Mutex
+)
+
+// recordLatency adds one observation for route, keyed like "GET /gary/image".
+func recordLatency(route string, d time.Duration) {
+	if latencySamples <= 0 {
+		return
+	}
+	latenciesMu.Lock()
+	defer latenciesMu.Unlock()
+	ring, ok := latencies[route]
+	if !ok {
+		ring = &latencyRing{samples: make([]time.Duration, 0, latencySamples)}
+		latencies[route] = ring
+	}
+	if len(ring.samples) < latencySamples {
+		ring.samples = append(ring.samples, d)
+	} else {
+		ring.samples[ring.next] = d
+	}
+	ring.next = (ring.next + 1) % latencySamples
+	ring.count++
+}
+
+// latencySnapshot summarizes every route's recent samples. count is the
+// total since startup; the average and p95 cover only the samples kept.
+func latencySnapshot() map[string]latencySummary {
+	latenciesMu.Lock()
+	defer latenciesMu.Unlock()
+	snapshot := make(map[string]latencySummary, len(latencies))
+	for route, ring := range latencies {
+		sorted := slices.Clone(ring.samples)
+		slices.Sort(sorted)
+		var total time.Duration
+		for _, d := range sorted {
+			total += d
+		}
+		p95 := sorted[int(math.Ceil(0.95*float64(len(sorted))))-1]
+		snapshot[route] = latencySummary{
+			Count: ring.count,
+			AvgMs: durationMs(total / time.Duration(len(sorted))),
+			P95Ms: durationMs(p95),
+		}
+	}
+	return snapshot
+}
+
+// durationMs converts d to milliseconds rounded to microseconds.
+func durationMs(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxLineCount caps ?count= on /quote and /joke (MAX_LINE_COUNT).
+var maxLineCount = 50
+
+// LineFile is one or more JSON arrays of lines (quotes, jokes, ...) merged
+// into a single pool held in memory and reloaded when any file changes.
+type LineFile struct {
+	paths []string
+	// jsonKey optionally names the array inside a JSON object, e.g. "quotes"
+	// or "data.quotes". An array at the root is still accepted.
+	jsonKey string
+	// locales holds translations by language code; see discoverLocales.
+	locales map[string]*LineFile
+
+	mu      sync.RWMutex
+	lines   []lineEntry
+	loadErr error
+	// appendMu serializes appendLine's read-modify-write of the file.
+	appendMu sync.Mutex
+}
+
+// lineEntry is one line of a LineFile. Files may hold plain strings or
+// {"text": "...", "author": "..."} objects, mixed freely.
+type lineEntry struct {
+	Text   string `json:"text"`
+	Author string `json:"author,omitempty"`
+}
+
+func (l *lineEntry) UnmarshalJSON(data []byte) error {
+	if json.Unmarshal(data, &l.Text) == nil {
+		return nil
+	}
+	type plain lineEntry
+	var obj plain
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	if obj.Text == "" {
+		return errors.New("line object without text")
+	}
+	*l = lineEntry(obj)
+	return nil
+}
+
+// MarshalJSON keeps the shape of the file: a plain string unless the line
+// has an author.
+func (l lineEntry) MarshalJSON() ([]byte, error) {
+	if l.Author == "" {
+		return json.Marshal(l.Text)
+	}
+	type plain lineEntry
+	return json.Marshal(plain(l))
+}
+
+// payload is the response body for a single line under key, e.g.
+// {"quote": "...", "author": "..."}.
+func (l lineEntry) payload(key string) fiber.Map {
+	resp := fiber.Map{key: l.Text}
+	if l.Author != "" {
+		resp["author"] = l.Author
+	}
+	return resp
+}
+
+// newLineFile loads the comma-separated list of paths in raw, reading the
+// array at jsonKey when the files hold an object, plus any translations.
+func newLineFile(raw, jsonKey string) *LineFile {
+	lf := &LineFile{paths: splitPaths(raw), jsonKey: jsonKey}
+	lf.reload()
+	lf.locales = discoverLocales(lf.paths, jsonKey)
+	return lf
+}
+
+func splitPaths(raw string) []string {
+	var paths []string
+	for _, path := range strings.Split(raw, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func loadLinesFromFile(filePath, jsonKey string) ([]lineEntry, error) {
+	fileContent, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file %s: %w", filePath, err)
+	}
+
+	lines, err := unmarshalLines(fileContent, jsonKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal JSON from %s: %w", filePath, err)
+	}
+
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no lines found in %s", filePath)
+	}
+	return lines, nil
+}
+
+// unmarshalLines decodes an array of lines, first from the dotted jsonKey
+// path inside an object when one is set, then from the root.
+func unmarshalLines(data []byte, jsonKey string) ([]lineEntry, error) {
+	var lines []lineEntry
+	if jsonKey != "" {
+		raw := json.RawMessage(data)
+		found := true
+		for _, part := range strings.Split(jsonKey, ".") {
+			var obj map[string]json.RawMessage
+			if json.Unmarshal(raw, &obj) != nil {
+				found = false
+				break
+			}
+			if raw, found = obj[part]; !found {
+				break
+			}
+		}
+		if found && json.Unmarshal(raw, &lines) == nil {
+			return lines, nil
+		}
+	}
+	if json.Unmarshal(data, &lines) == nil {
+		return lines, nil
+	}
+
+	if jsonKey != "" {
+		return nil, fmt.Errorf("expected an array of lines, or an object with an array of lines at %q (lines are strings or {\"text\", \"author\"} objects)", jsonKey)
+	}
+	return nil, errors.New("expected an array of lines at the root (strings or {\"text\", \"author\"} objects)")
+}
+
+// loadLinesFromFiles concatenates the lines of every file in paths, failing
+// if any one of them can't be loaded.
+func loadLinesFromFiles(paths []string, jsonKey string) ([]lineEntry, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no file configured")
+	}
+	var lines []lineEntry
+	for _, path := range paths {
+		fileLines, err := loadLinesFromFile(path, jsonKey)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fileLines...)
+	}
+	return lines, nil
+}
+
+// reload re-reads every file. On failure the previously loaded lines are kept,
+// so a half-saved file never drops the others' lines either.
+func (lf *LineFile) reload() error {
+	lines, err := loadLinesFromFiles(lf.paths, lf.jsonKey)
+
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if err != nil {
+		fmt.Printf("Error loading %s: %v\n", strings.Join(lf.paths, ","), err)
+		if lf.lines == nil {
+			lf.loadErr = err
+		}
+		return err
+	}
+	lf.lines = lines
+	lf.loadErr = nil
+	return nil
+}
+
+func (lf *LineFile) random() (lineEntry, error) {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+	if lf.loadErr != nil {
+		return lineEntry{}, lf.loadErr
+	}
+	return lf.lines[randIntN(len(lf.lines))], nil
+}
+
+// sample returns count random lines. With unique set it samples without
+// replacement, so the result may be shorter than count.
+func (lf *LineFile) sample(count int, unique bool) ([]lineEntry, error) {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+	if lf.loadErr != nil {
+		return nil, lf.loadErr
+	}
+	picks := make([]lineEntry, 0, count)
+	if !unique {
+		for range count {
+			picks = append(picks, lf.lines[randIntN(len(lf.lines))])
+		}
+		return picks, nil
+	}
+	for _, i := range randPerm(len(lf.lines)) {
+		if len(picks) == count {
+			break
+		}
+		picks = append(picks, lf.lines[i])
+	}
+	return picks, nil
+}
+
+func (lf *LineFile) count() int {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+	return len(lf.lines)
+}
+
+// at returns the line at index, reporting false when it is out of range.
+func (lf *LineFile) at(index int) (lineEntry, bool, error) {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+	if lf.loadErr != nil {
+		return lineEntry{}, false, lf.loadErr
+	}
+	if index < 0 || index >= len(lf.lines) {
+		return lineEntry{}, false, nil
+	}
+	return lf.lines[index], true, nil
+}
+
+// search returns every line whose text or author contains query,
+// case-insensitively, in file order.
+func (lf *LineFile) search(query string) ([]lineEntry, error) {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+	if lf.loadErr != nil {
+		return nil, lf.loadErr
+	}
+	query = strings.ToLower(query)
+	matches := []lineEntry{}
+	for _, line := range lf.lines {
+		if strings.Contains(strings.ToLower(line.Text), query) || strings.Contains(strings.ToLower(line.Author), query) {
+			matches = append(matches, line)
+		}
+	}
+	return matches, nil
+}
+
+// checkReadable reports the first file that can no longer be opened.
+func (lf *LineFile) checkReadable() error {
+	if len(lf.paths) == 0 {
+		return errors.New("no file configured")
+	}
+	for _, path := range lf.paths {
+		if err := checkFileReadable(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startLineFileWatcher watches each file's parent directory rather than the
+// file itself, so atomic replace-by-rename saves are still picked up.
+func startLineFileWatcher(lf *LineFile) *fsnotify.Watcher {
+	if len(lf.paths) == 0 {
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Printf("Failed to create watcher for %s: %v\n", strings.Join(lf.paths, ","), err)
+		return nil
+	}
+	targets := map[string]bool{}
+	for _, path := range lf.paths {
+		target := filepath.Clean(path)
+		targets[target] = true
+		if err := watcher.Add(filepath.Dir(target)); err != nil {
+			fmt.Printf("Failed to watch directory for %s: %v\n", path, err)
+			watcher.Close()
+			return nil
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				target := filepath.Clean(event.Name)
+				if !targets[target] {
+					continue
+				}
+				if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) != 0 {
+					if lf.reload() == nil {
+						fmt.Printf("[%s] Lines reloaded due to event: %s\n", filepath.Base(target), event)
+					}
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Printf("[%s] Watcher error: %v\n", strings.Join(lf.paths, ","), err)
+			}
+		}
+	}()
+	return watcher
+}
+
+// serveRandomLineHandler answers with a random line under key, e.g.
+// {"quote": "..."}, plus "author" when the line has one, or several with
+// ?count=.
+func serveRandomLineHandler(lf *LineFile, key string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, randomCacheControl)
+		if c.Query("count") != "" {
+			return serveMultipleLines(c, lf.withLocale(c), key)
+		}
+		line, err := lf.withLocale(c).random()
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err.Error())
+		}
+
+		return c.Status(fiber.StatusOK).JSON(line.payload(key))
+	}
+}
+
+// serveMultipleLines answers ?count=N with {"<key>s": [...]}, each entry
+// shaped like a single-line response.
+func serveMultipleLines(c *fiber.Ctx, lf *LineFile, key string) error {
+	count := c.QueryInt("count")
+	if count < 1 || count > maxLineCount {
+		return sendError(c, fiber.StatusBadRequest, fmt.Sprintf("count must be between 1 and %d", maxLineCount))
+	}
+	lines, err := lf.sample(count, c.QueryBool("unique"))
+	if err != nil {
+		return sendError(c, fiber.StatusInternalServerError, err.Error())
+	}
+	payloads := make([]fiber.Map, 0, len(lines))
+	for _, line := range lines {
+		payloads = append(payloads, line.payload(key))
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{key + "s": payloads})
+}
+
+func serveLineByIndexHandler(lf *LineFile, key string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		index, err := c.ParamsInt("index")
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, "invalid index")
+		}
+
+		line, ok, err := lf.withLocale(c).at(index)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err.Error())
+		}
+		if !ok {
+			return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no %s at index %d", key, index))
+		}
+		resp := line.payload(key)
+		resp["index"] = index
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+}
+
+func serveLineSearchHandler(lf *LineFile) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query := strings.TrimSpace(c.Query("q"))
+		if query == "" {
+			return sendError(c, fiber.StatusBadRequest, "missing query parameter q")
+		}
+		limit := c.QueryInt("limit", defaultListLimit)
+		if limit < 1 || limit > maxListLimit {
+			return sendError(c, fiber.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+		}
+
+		matches, err := lf.withLocale(c).search(query)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err.Error())
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"matches": matches[:min(limit, len(matches))],
+			"count":   len(matches),
+		})
+	}
+}
+
+func serveLineCountHandler(lf *LineFile) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"count": lf.withLocale(c).count()})
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// lineRules are the checks a posted quote or joke must pass before it is
+// appended: LINE_MIN_LENGTH and LINE_MAX_LENGTH bound the text,
+// LINE_AUTHOR_MAX_LENGTH bounds the author, and LINE_REQUIRE_AUTHOR makes
+// the author mandatory. Lengths count characters, not bytes.
+var lineRules = struct {
+	minLength       int
+	maxLength       int
+	authorMaxLength int
+	requireAuthor   bool
+}{minLength: 1, maxLength: 500, authorMaxLength: 100}
+
+// lineUpload is the body of POST /quote and POST /joke, the same
+// {"text", "author"} shape the files use.
+type lineUpload struct {
+	Text   *string `json:"text"`
+	Author *string `json:"author"`
+}
+
+// validate returns one message per invalid field, or nil when the entry
+// passes lineRules.
+func (u lineUpload) validate() map[string]string {
+	fields := map[string]string{}
+	switch text := strings.TrimSpace(deref(u.Text)); {
+	case u.Text == nil:
+		fields["text"] = "is required"
+	case text == "":
+		fields["text"] = "must not be empty"
+	case utf8.RuneCountInString(text) < lineRules.minLength:
+		fields["text"] = fmt.Sprintf("must be at least %d characters", lineRules.minLength)
+	case utf8.RuneCountInString(text) > lineRules.maxLength:
+		fields["text"] = fmt.Sprintf("must be at most %d characters", lineRules.maxLength)
+	}
+	switch author := strings.TrimSpace(deref(u.Author)); {
+	case author == "" && lineRules.requireAuthor:
+		fields["author"] = "is required"
+	case utf8.RuneCountInString(author) > lineRules.authorMaxLength:
+		fields["author"] = fmt.Sprintf("must be at most %d characters", lineRules.authorMaxLength)
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// decodeLineUpload parses a JSON body strictly, reporting an unknown field or
+// a value of the wrong type against that field. Anything but a single object
+// is an error.
+func decodeLineUpload(body []byte) (lineUpload, map[string]string, error) {
+	var upload lineUpload
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&upload)
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		if _, trailing := dec.Token(); trailing != io.EOF {
+			return upload, nil, errors.New("trailing data after the JSON object")
+		}
+		return upload, nil, nil
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return upload, map[string]string{typeErr.Field: "must be a string"}, nil
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return upload, map[string]string{field: "is not allowed"}, nil
+	}
+	return upload, nil, err
+}
+
+// appendLine adds entry to the last of lf's files and reloads, returning the
+// new line's index. The array at jsonKey is used when the file holds an
+// object, as on load. The file is replaced by rename, so the watcher and
+// concurrent readers never see it half-written.
+func (lf *LineFile) appendLine(entry lineEntry) (int, error) {
+	if len(lf.paths) == 0 {
+		return 0, errors.New("no file configured")
+	}
+	lf.appendMu.Lock()
+	defer lf.appendMu.Unlock()
+
+	path := lf.paths[len(lf.paths)-1]
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("could not read file %s: %w", path, err)
+	}
+	var keys []string
+	if lf.jsonKey != "" {
+		keys = strings.Split(lf.jsonKey, ".")
+	}
+	updated, err := appendToArray(data, keys, entry)
+	if err != nil && keys != nil {
+		updated, err = appendToArray(data, nil, entry)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not append to %s: %w", path, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return 0, err
+	}
+	_, writeErr := tmp.Write(append(updated, '\n'))
+	closeErr := tmp.Close()
+	if err := errors.Join(writeErr, closeErr, os.Chmod(tmp.Name(), info.Mode().Perm())); err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	if err := lf.reload(); err != nil {
+		return 0, err
+	}
+	return lf.count() - 1, nil
+}
+
+// appendToArray appends entry to the JSON array found by following keys
+// through nested objects, re-encoding the document with two-space indents.
+func appendToArray(data []byte, keys []string, entry lineEntry) ([]byte, error) {
+	if len(keys) == 0 {
+		var lines []json.RawMessage
+		if err := json.Unmarshal(data, &lines); err != nil {
+			return nil, errors.New("expected an array of lines")
+		}
+		// Encoded here rather than via lineEntry.MarshalJSON, which escapes
+		// HTML; the shape is the same.
+		var value any = entry.Text
+		if entry.Author != "" {
+			type plain lineEntry
+			value = plain(entry)
+		}
+		encoded, err := encodeIndented(value)
+		if err != nil {
+			return nil, err
+		}
+		return encodeIndented(append(lines, encoded))
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, errors.New("expected an object")
+	}
+	inner, ok := obj[keys[0]]
+	if !ok {
+		return nil, fmt.Errorf("no key %q", keys[0])
+	}
+	updated, err := appendToArray(inner, keys[1:], entry)
+	if err != nil {
+		return nil, err
+	}
+	obj[keys[0]] = updated
+	return encodeIndented(obj)
+}
+
+// encodeIndented is json.MarshalIndent without HTML escaping, so lines like
+// "Tom & Jerry" are written back as they were.
+func encodeIndented(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// serveLineUploadHandler validates a posted quote or joke and appends it to
+// lf's file, answering 422 with the failing fields when it breaks lineRules.
+func serveLineUploadHandler(lf *LineFile, key string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !c.Is("json") {
+			return sendError(c, fiber.StatusUnsupportedMediaType, "body must be application/json")
+		}
+		upload, fields, err := decodeLineUpload(c.Body())
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, "body must be a JSON object")
+		}
+		if fields == nil {
+			fields = upload.validate()
+		}
+		if fields != nil {
+			return sendFieldErrors(c, fiber.StatusUnprocessableEntity, fmt.Sprintf("invalid %s", key), fields)
+		}
+
+		entry := lineEntry{Text: strings.TrimSpace(*upload.Text), Author: strings.TrimSpace(deref(upload.Author))}
+		index, err := lf.appendLine(entry)
+		if err != nil {
+			fmt.Printf("Failed to append %s: %v\n", key, err)
+			return sendError(c, fiber.StatusInternalServerError, fmt.Sprintf("could not save %s", key))
+		}
+		fmt.Printf("Added %s #%d\n", key, index)
+		resp := entry.payload(key)
+		resp["index"] = index
+		return c.Status(fiber.StatusCreated).JSON(resp)
+	}
+}
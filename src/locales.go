@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultLineLang is the language of the configured QUOTES_FILE and
+// JOKES_FILE themselves (LINES_DEFAULT_LANG).
+var defaultLineLang = "en"
+
+// langCode matches the language part of a sibling like quotes.fr.json or
+// quotes.pt-BR.json.
+var langCode = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// discoverLocales finds translations of the files in paths by naming
+// convention: quotes.json is the default language and quotes.fr.json next to
+// it is French. With several paths, a language gets whichever of them have a
+// translation. Languages are discovered once at startup.
+func discoverLocales(paths []string, jsonKey string) map[string]*LineFile {
+	byLang := map[string][]string{}
+	for _, path := range paths {
+		ext := filepath.Ext(path)
+		stem := strings.TrimSuffix(path, ext)
+		matches, _ := filepath.Glob(stem + ".*" + ext)
+		for _, match := range matches {
+			lang := strings.TrimSuffix(strings.TrimPrefix(match, stem+"."), ext)
+			if !langCode.MatchString(lang) {
+				continue
+			}
+			lang = strings.ToLower(lang)
+			if lang == defaultLineLang {
+				fmt.Printf("Ignoring %s: %s is already the default language (LINES_DEFAULT_LANG)\n", match, lang)
+				continue
+			}
+			byLang[lang] = append(byLang[lang], match)
+		}
+	}
+
+	locales := make(map[string]*LineFile, len(byLang))
+	for lang, langPaths := range byLang {
+		locale := &LineFile{paths: langPaths, jsonKey: jsonKey}
+		locale.reload()
+		locales[lang] = locale
+	}
+	return locales
+}
+
+// forLang returns the line file for the requested language and the language
+// actually served. A regional code like fr-CA falls back to fr, and anything
+// unavailable falls back to the default language.
+func (lf *LineFile) forLang(lang string) (*LineFile, string) {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if locale, ok := lf.locales[lang]; ok {
+		return locale, lang
+	}
+	base, _, _ := strings.Cut(lang, "-")
+	if locale, ok := lf.locales[base]; ok {
+		return locale, base
+	}
+	return lf, defaultLineLang
+}
+
+// withLocale picks the line file for ?lang= and, when translations exist,
+// reports the language served in Content-Language.
+func (lf *LineFile) withLocale(c *fiber.Ctx) *LineFile {
+	if len(lf.locales) == 0 {
+		return lf
+	}
+	file, lang := lf.forLang(c.Query("lang"))
+	c.Set(fiber.HeaderContentLanguage, lang)
+	return file
+}
+
+func (lf *LineFile) langs() []string {
+	langs := []string{defaultLineLang}
+	for lang := range lf.locales {
+		langs = append(langs, lang)
+	}
+	slices.Sort(langs)
+	return langs
+}
+
+// withLocales expands lineFiles with an entry per translation, e.g.
+// "quotes.fr", for everything that should cover every file on disk.
+func withLocales(lineFiles map[string]*LineFile) map[string]*LineFile {
+	all := make(map[string]*LineFile, len(lineFiles))
+	for name, lf := range lineFiles {
+		all[name] = lf
+		for lang, locale := range lf.locales {
+			all[name+"."+lang] = locale
+		}
+	}
+	return all
+}
+
+func serveLineLangsHandler(lf *LineFile) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"default": defaultLineLang,
+			"langs":   lf.langs(),
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+	size  int64
+}
+
+// lruCache is an LRU keyed by file path and bounded by the total size of its
+// values, as reported by sizeOf. A value larger than the whole budget is not
+// cached, and a budget of 0 or less caches nothing.
+type lruCache[V any] struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	sizeOf   func(V) int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+func newLRUCache[V any](maxBytes int64, sizeOf func(V) int64) *lruCache[V] {
+	return &lruCache[V]{maxBytes: maxBytes, sizeOf: sizeOf, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (lc *lruCache[V]) get(key string) (V, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	el, ok := lc.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	lc.order.MoveToFront(el)
+	return el.Value.(*lruEntry[V]).value, true
+}
+
+// put stores value under key, evicting the least recently used entries until
+// the cache fits its budget again.
+func (lc *lruCache[V]) put(key string, value V) {
+	size := lc.sizeOf(value)
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.removeLocked(key)
+	if size > lc.maxBytes {
+		return
+	}
+	lc.entries[key] = lc.order.PushFront(&lruEntry[V]{key: key, value: value, size: size})
+	lc.bytes += size
+	for lc.bytes > lc.maxBytes {
+		lc.removeLocked(lc.order.Back().Value.(*lruEntry[V]).key)
+	}
+}
+
+func (lc *lruCache[V]) remove(key string) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.removeLocked(key)
+}
+
+func (lc *lruCache[V]) removeLocked(key string) {
+	el, ok := lc.entries[key]
+	if !ok {
+		return
+	}
+	lc.order.Remove(el)
+	delete(lc.entries, key)
+	lc.bytes -= el.Value.(*lruEntry[V]).size
+}
+
+func (lc *lruCache[V]) clear() {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.order.Init()
+	clear(lc.entries)
+	lc.bytes = 0
+}
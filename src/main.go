@@ -1,280 +1,291 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"math/rand"
+	"net"
 	"os"
-	"path/filepath"
-	"regexp"
+	"os/signal"
 	"runtime"
-	"sync"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/joho/godotenv"
 )
 
-const (
-	defaultGaryImg   = "Gary76.jpg"
-	defaultGooberImg = "goober8.jpg"
-	defaultGullyImg  = "Gully1.jpg"
-)
-
-var (
-	garyImages   []string
-	gooberImages []string
-	gullyImages  []string
-	imageCacheMu sync.RWMutex
-)
-
-func cacheFileNames(dirPath string) []string {
-	files, err := os.ReadDir(dirPath)
-	if err != nil {
-		fmt.Printf("Error reading dir %s: %v\n", dirPath, err)
-		return nil
-	}
+func main() {
+	_ = godotenv.Load()
+	startTime := time.Now().UTC()
 
-	names := make([]string, 0, len(files))
-	for _, file := range files {
-		if !file.IsDir() {
-			names = append(names, file.Name())
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	seedRandomFromEnv()
+	noImmediateRepeat = os.Getenv("NO_IMMEDIATE_REPEAT") == "true"
+	recentWindow = envInt("RECENT_WINDOW", 0)
+	recursiveScan = os.Getenv("RECURSIVE_SCAN") == "true"
+	dedupImages = os.Getenv("DEDUP") == "true"
+	webpTranscode = os.Getenv("WEBP_TRANSCODE") == "true"
+	webpCache.maxBytes = int64(envInt("WEBP_CACHE_BYTES", defaultWebPCacheBytes))
+	mosaicEnabled = os.Getenv("MOSAIC_ENABLED") == "true"
+	mosaicCells.maxBytes = int64(envInt("MOSAIC_CACHE_BYTES", defaultMosaicCacheBytes))
+	numberStrategy = numberStrategyFromEnv()
+	recencyCurve = recencyCurveFromEnv()
+	recencyHalfLife = max(envInt("RECENCY_HALF_LIFE", recencyHalfLife), 1)
+	if raw := os.Getenv("NIGHT_HOURS"); raw != "" {
+		tag := os.Getenv("NIGHT_TAG")
+		if tag == "" {
+			tag = "night"
+		}
+		if err := parseNightHours(raw, tag); err != nil {
+			fmt.Printf("Invalid NIGHT_HOURS, night mode disabled: %v\n", err)
 		}
 	}
-	return names
-}
-
-func getRandomFileName(images []string, defaultName string) string {
-	if len(images) == 0 {
-		return defaultName
+	maxImageCount = envInt("MAX_IMAGE_COUNT", maxImageCount)
+	maxLineCount = envInt("MAX_LINE_COUNT", maxLineCount)
+	payloadCacheSize = envInt("PAYLOAD_CACHE_SIZE", 0)
+	maxConcurrentReads = envInt("MAX_CONCURRENT_READS", 0)
+	readWaitTimeout = envDuration("READ_WAIT_TIMEOUT", readWaitTimeout)
+	breakerFailures = envInt("BREAKER_FAILURES", 0)
+	breakerWindow = envDuration("BREAKER_WINDOW", breakerWindow)
+	breakerCooldown = envDuration("BREAKER_COOLDOWN", breakerCooldown)
+	breakerReadTimeout = envDuration("BREAKER_READ_TIMEOUT", breakerReadTimeout)
+	watcherEventCount = envInt("WATCHER_EVENTS", defaultWatcherEventCount)
+	watchDebounce = envDuration("WATCH_DEBOUNCE", watchDebounce)
+	streamMaxClients = envInt("STREAM_MAX_CLIENTS", defaultStreamMaxClients)
+	if wsMinInterval = envDuration("WS_MIN_INTERVAL", defaultWSMinInterval); wsMinInterval <= 0 {
+		fmt.Printf("Invalid WS_MIN_INTERVAL %s, using %s\n", wsMinInterval, defaultWSMinInterval)
+		wsMinInterval = defaultWSMinInterval
+	}
+	wsMaxClients = envInt("WS_MAX_CLIENTS", defaultWSMaxClients)
+	retryAfterSeconds = envInt("RETRY_AFTER_SECONDS", retryAfterSeconds)
+	latencySamples = envInt("LATENCY_SAMPLES", defaultLatencySamples)
+	if os.Getenv("MAINTENANCE") == "true" {
+		setMaintenance(true, "MAINTENANCE")
+	}
+	if v := os.Getenv("RANDOM_CACHE_CONTROL"); v != "" {
+		randomCacheControl = v
+	}
+	if v := os.Getenv("IMAGE_CACHE_CONTROL"); v != "" {
+		imageCacheControl = v
+	}
+	routePrefix = normalizeRoutePrefix(os.Getenv("ROUTE_PREFIX"))
+	disabledEndpoints = parseDisabledEndpoints(os.Getenv("DISABLED_ENDPOINTS"))
+	uploadToken = os.Getenv("UPLOAD_TOKEN")
+	uploadMaxBytes = int64(envInt("UPLOAD_MAX_BYTES", defaultUploadMaxBytes))
+	maxDecodePixels = envInt("MAX_DECODE_PIXELS", maxDecodePixels)
+	dataURIMaxBytes = int64(envInt("DATA_URI_MAX_BYTES", defaultDataURIMaxBytes))
+	lineRules.minLength = envInt("LINE_MIN_LENGTH", lineRules.minLength)
+	lineRules.maxLength = envInt("LINE_MAX_LENGTH", lineRules.maxLength)
+	lineRules.authorMaxLength = envInt("LINE_AUTHOR_MAX_LENGTH", lineRules.authorMaxLength)
+	lineRules.requireAuthor = os.Getenv("LINE_REQUIRE_AUTHOR") == "true"
+	if exts := os.Getenv("IMAGE_EXTENSIONS"); exts != "" {
+		allowedExtensions = parseExtensions(exts)
 	}
-	return images[rand.Intn(len(images))]
-}
 
-func getRandomLineFromFile(filePath string) (string, error) {
-	fileContent, err := os.ReadFile(filePath)
-	if err != nil {
-		return "", fmt.Errorf("could not read file %s: %w", filePath, err)
+	categories := loadCategories()
+	embeddedFallback = os.Getenv("EMBEDDED_FALLBACK") == "true"
+	if os.Getenv("STRICT_CONFIG") != "false" {
+		if problems := validateConfig(categories); len(problems) > 0 {
+			for _, problem := range problems {
+				fmt.Printf("Config error: %s\n", problem)
+			}
+			fmt.Println("Set STRICT_CONFIG=false to start anyway.")
+			os.Exit(1)
+		}
 	}
 
-	var lines []string
-	err = json.Unmarshal(fileContent, &lines)
+	certFile, keyFile, err := tlsFromEnv()
 	if err != nil {
-		return "", fmt.Errorf("could not unmarshal JSON from %s: %w", filePath, err)
+		fmt.Printf("Config error: %v\n", err)
+		os.Exit(1)
 	}
-
-	if len(lines) == 0 {
-		return "", fmt.Errorf("no lines found in %s", filePath)
+	// Unpacked after the checks above, which exit on failure, so the
+	// temporary directories are always removed on shutdown.
+	for _, cat := range categories {
+		cat.unpackEmbeddedFallback()
 	}
-	return lines[rand.Intn(len(lines))], nil
-}
 
-func extractNumberFromFilename(filename string) int {
-	re := regexp.MustCompile(`\d+`)
-	match := re.FindString(filename)
-	if match == "" {
-		return 0
+	appConfig := fiber.Config{
+		ErrorHandler: handleError,
+		// Leave headroom over the upload limit for multipart framing.
+		BodyLimit: max(fiber.DefaultBodyLimit, int(uploadMaxBytes)+1<<20),
 	}
-	var number int
-	fmt.Sscanf(match, "%d", &number)
-	return number
-}
-
-func serveRandomImageHandler(images *[]string, defaultImage, imageDir string) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		c.Set("Cache-Control", "no-store")
-		imageCacheMu.RLock()
-		imageName := getRandomFileName(*images, defaultImage)
-		imageCacheMu.RUnlock()
-		return c.SendFile(filepath.Join(imageDir, imageName))
+	// c.IP() only reads ProxyHeader on requests from TRUSTED_PROXIES and is
+	// the socket address otherwise, so clients can't spoof the IP used in
+	// logs and rate limits.
+	if proxies := trustedProxiesFromEnv(); len(proxies) > 0 {
+		appConfig.ProxyHeader = fiber.HeaderXForwardedFor
+		if header := os.Getenv("PROXY_HEADER"); header != "" {
+			appConfig.ProxyHeader = header
+		}
+		appConfig.EnableTrustedProxyCheck = true
+		appConfig.TrustedProxies = proxies
+		appConfig.EnableIPValidation = true
 	}
-}
-
-func serveImageURLHandler(baseURL string, images *[]string, defaultImage string) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		imageCacheMu.RLock()
-		imageName := getRandomFileName(*images, defaultImage)
-		imageCacheMu.RUnlock()
+	app := fiber.New(appConfig)
+	app.Use(recover.New())
+	// Runs before the logger so every log line carries the X-Request-ID.
+	app.Use(requestid.New())
+	app.Use(newRequestLogger())
+	app.Use(cors.New(corsConfig()))
+	// Only compressible content types (JSON, text) are encoded; image bytes
+	// are passed through untouched.
+	app.Use(compress.New(compress.Config{Level: compressLevel()}))
+	app.Use(metricsMiddleware())
+	if rateLimiter := newRateLimiter(); rateLimiter != nil {
+		app.Use(rateLimiter)
+	}
+	app.Use(maintenanceMiddleware)
 
-		number := extractNumberFromFilename(imageName)
+	if lang := strings.ToLower(os.Getenv("LINES_DEFAULT_LANG")); lang != "" {
+		defaultLineLang = lang
+	}
+	quotes := newLineFile(os.Getenv("QUOTES_FILE"), os.Getenv("QUOTES_KEY"))
+	jokes := newLineFile(os.Getenv("JOKES_FILE"), os.Getenv("JOKES_KEY"))
 
-		cleanBaseURL := baseURL
-		if len(cleanBaseURL) > 0 && cleanBaseURL[len(cleanBaseURL)-1] == '/' {
-			cleanBaseURL = cleanBaseURL[:len(cleanBaseURL)-1]
+	var watchers []*fsnotify.Watcher
+	addWatcher := func(w *fsnotify.Watcher) {
+		if w != nil {
+			watchers = append(watchers, w)
 		}
-		url := fmt.Sprintf("%s/%s", cleanBaseURL, imageName)
+	}
+	for _, lf := range withLocales(map[string]*LineFile{"quotes": quotes, "jokes": jokes}) {
+		addWatcher(startLineFileWatcher(lf))
+	}
 
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"url":    url,
-			"number": number,
-		})
+	// Every route lives under ROUTE_PREFIX (empty by default). Middleware stays
+	// on app so it also covers paths outside the prefix.
+	var router fiber.Router = app
+	if routePrefix != "" {
+		router = app.Group(routePrefix)
 	}
-}
 
-func serveRandomLineHandler(filePath string) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		line, err := getRandomLineFromFile(filePath)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	warmDimensions := os.Getenv("WARM_DIMENSIONS") == "true"
+	validateOnServe = warmDimensions
+	for _, cat := range categories {
+		cat.warmUp(warmDimensions)
+		addWatcher(startDirectoryWatcher(cat, cat.dir))
+		if cat.fallbackDir != "" {
+			addWatcher(startDirectoryWatcher(cat, cat.fallbackDir))
 		}
+		registerCategoryRoutes(router, cat)
+	}
 
-		var key string
-		switch filepath.Base(filePath) {
-		case filepath.Base(os.Getenv("QUOTES_FILE")):
-			key = "quote"
-		case filepath.Base(os.Getenv("JOKES_FILE")):
-			key = "joke"
-		default:
-			key = "line"
-		}
+	reloadOnSIGHUP(categories, withLocales(map[string]*LineFile{"quotes": quotes, "jokes": jokes}))
 
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{key: line})
+	if endpointEnabled("random") {
+		router.Get("/random", serveRandomCategoryHandler(categories))
 	}
-}
-
-func startDirectoryWatcher(dir string, cache *[]string, label string) {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		fmt.Printf("Failed to create watcher for %s: %v\n", label, err)
-		return
+	if endpointEnabled("categories") {
+		router.Get("/categories", serveCategoriesHandler(categories))
 	}
-	err = watcher.Add(dir)
-	if err != nil {
-		fmt.Printf("Failed to watch directory %s: %v\n", dir, err)
-		return
+	digestLines := map[string]*LineFile{}
+	if endpointEnabled("quote") {
+		router.Get("/quote", serveRandomLineHandler(quotes, "quote"))
+		router.Get("/quote/count", serveLineCountHandler(quotes))
+		router.Get("/quote/search", serveLineSearchHandler(quotes))
+		router.Get("/quote/langs", serveLineLangsHandler(quotes))
+		router.Get("/quote/:index<int>", serveLineByIndexHandler(quotes, "quote"))
+		if endpointEnabled("upload") {
+			router.Post("/quote", requireUploadToken, serveLineUploadHandler(quotes, "quote"))
+		}
+		digestLines["quote"] = quotes
 	}
-
-	go func() {
-		defer watcher.Close()
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
-				if event.Op&(fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
-					imageCacheMu.Lock()
-					*cache = cacheFileNames(dir)
-					imageCacheMu.Unlock()
-					fmt.Printf("[%s] Cache updated due to event: %s\n", label, event)
-				}
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
-				fmt.Printf("[%s] Watcher error: %v\n", label, err)
-			}
+	if endpointEnabled("joke") {
+		router.Get("/joke", serveRandomLineHandler(jokes, "joke"))
+		router.Get("/joke/count", serveLineCountHandler(jokes))
+		router.Get("/joke/search", serveLineSearchHandler(jokes))
+		router.Get("/joke/langs", serveLineLangsHandler(jokes))
+		router.Get("/joke/:index<int>", serveLineByIndexHandler(jokes, "joke"))
+		if endpointEnabled("upload") {
+			router.Post("/joke", requireUploadToken, serveLineUploadHandler(jokes, "joke"))
 		}
-	}()
-}
-
-func main() {
-	_ = godotenv.Load()
-	startTime := time.Now().UTC()
-
-	runtime.GOMAXPROCS(runtime.NumCPU())
-	rand.Seed(time.Now().UnixNano())
-
-	app := fiber.New()
-	app.Use(recover.New())
-	app.Use(logger.New())
-
-	garyDir := os.Getenv("GARY_DIR")
-	gooberDir := os.Getenv("GOOBER_DIR")
-	gullyDir := os.Getenv("GULLY_DIR")
-	quotesPath := os.Getenv("QUOTES_FILE")
-	jokesPath := os.Getenv("JOKES_FILE")
-
-	garyImages = cacheFileNames(garyDir)
-	gooberImages = cacheFileNames(gooberDir)
-	gullyImages = cacheFileNames(gullyDir)
-	startDirectoryWatcher(garyDir, &garyImages, "Gary")
-	startDirectoryWatcher(gooberDir, &gooberImages, "Goober")
-	startDirectoryWatcher(gullyDir, &gullyImages, "Gully")
-
-	app.Static("/Gary", garyDir)
-	app.Static("/Goober", gooberDir)
-	app.Static("/Gully", gullyDir)
-
-	app.Get("/gary/image", serveRandomImageHandler(&garyImages, defaultGaryImg, garyDir))
-	app.Get("/gary/image/*", serveRandomImageHandler(&garyImages, defaultGaryImg, garyDir))
-	app.Get("/goober/image", serveRandomImageHandler(&gooberImages, defaultGooberImg, gooberDir))
-	app.Get("/goober/image/*", serveRandomImageHandler(&gooberImages, defaultGooberImg, gooberDir))
-	app.Get("/gully/image", serveRandomImageHandler(&gullyImages, defaultGullyImg, gullyDir))
-	app.Get("/gully/image/*", serveRandomImageHandler(&gullyImages, defaultGullyImg, gullyDir))
-
-	garyBaseURL := os.Getenv("GARYURL")
-	gooberBaseURL := os.Getenv("GOOBERURL")
-	gullyBaseURL := os.Getenv("GULLYURL")
-
-	app.Get("/gary", serveImageURLHandler(garyBaseURL, &garyImages, defaultGaryImg))
-	app.Get("/goober", serveImageURLHandler(gooberBaseURL, &gooberImages, defaultGooberImg))
-	app.Get("/gully", serveImageURLHandler(gullyBaseURL, &gullyImages, defaultGullyImg))
-	app.Get("/quote", serveRandomLineHandler(quotesPath))
-	app.Get("/joke", serveRandomLineHandler(jokesPath))
-
-	app.Get("/info", func(c *fiber.Ctx) error {
-		handlerStart := time.Now()
-		c.Set("Cache-Control", "no-store")
+		digestLines["joke"] = jokes
+	}
 
-		now := time.Now().UTC()
-		uptime := now.Sub(startTime)
+	if endpointEnabled("digest") {
+		router.Get("/digest", serveDigestHandler(categories, digestLines))
+	}
 
-		resp := fiber.Map{
-			"now":           now.Format(time.RFC3339Nano),
-			"start_time":    startTime.Format(time.RFC3339Nano),
-			"uptime_ms":     uptime.Milliseconds(),
-			"go_version":    runtime.Version(),
-			"num_goroutine": runtime.NumGoroutine(),
-			"num_cpu":       runtime.NumCPU(),
-			"gomaxprocs":    runtime.GOMAXPROCS(0),
-		}
-		resp["latency_ms"] = time.Since(handlerStart).Milliseconds()
-		return c.Status(fiber.StatusOK).JSON(resp)
-	})
+	if endpointEnabled("info") {
+		router.Get("/info", serveInfoHandler(startTime, categories))
+	}
+	if endpointEnabled("stats") {
+		router.Get("/stats", serveStatsHandler(categories, map[string]*LineFile{"quotes": quotes, "jokes": jokes}))
+	}
+	if endpointEnabled("metrics") {
+		router.Get("/metrics", metricsHandler())
+	}
+	if endpointEnabled("openapi") {
+		router.Get("/openapi.json", serveOpenAPIHandler)
+	}
 
-	app.Get("/health", func(c *fiber.Ctx) error {
-		c.Set("Cache-Control", "no-store")
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"status": "ok",
-		})
-	})
+	if endpointEnabled("admin") {
+		router.Get("/admin", requireAdminToken, serveAdminHandler)
+		router.Get("/admin/events", requireAdminToken, serveAdminEventsHandler(categories))
+		router.Get("/admin/maintenance", requireAdminToken, serveMaintenanceHandler)
+		router.Post("/admin/maintenance", requireAdminToken, serveMaintenanceToggleHandler)
+	}
 
-	app.Get("/gary/count", func(c *fiber.Ctx) error {
-		imageCacheMu.RLock()
-		count := len(garyImages)
-		imageCacheMu.RUnlock()
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{"count": count})
-	})
-	app.Get("/goober/count", func(c *fiber.Ctx) error {
-		imageCacheMu.RLock()
-		count := len(gooberImages)
-		imageCacheMu.RUnlock()
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{"count": count})
-	})
-	app.Get("/gully/count", func(c *fiber.Ctx) error {
-		imageCacheMu.RLock()
-		count := len(gullyImages)
-		imageCacheMu.RUnlock()
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{"count": count})
-	})
+	router.Get("/health", serveReadinessHandler(categories, withLocales(map[string]*LineFile{"quotes": quotes, "jokes": jokes})))
+	router.Get("/health/live", serveLivenessHandler)
 
 	indexFile := os.Getenv("INDEX_FILE")
 	if indexFile != "" {
-		app.Get("/", func(c *fiber.Ctx) error {
+		router.Get("/", func(c *fiber.Ctx) error {
 			c.Set("Cache-Control", "no-store")
 			return c.SendFile(indexFile)
 		})
 	}
 
+	// Browsers ask for the favicon at the root whatever ROUTE_PREFIX is.
+	// Without one configured, 204 keeps them from logging 404s.
+	faviconFile := os.Getenv("FAVICON_FILE")
+	app.Get("/favicon.ico", func(c *fiber.Ctx) error {
+		c.Set("Cache-Control", "public, max-age=604800")
+		if faviconFile == "" {
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+		return c.SendFile(faviconFile)
+	})
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
-	if err := app.Listen(":" + port); err != nil {
-		fmt.Printf("Failed to start the server: %v\n", err)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		addr := net.JoinHostPort(os.Getenv("BIND_ADDR"), port)
+		var err error
+		if certFile != "" {
+			err = app.ListenTLS(addr, certFile, keyFile)
+		} else {
+			err = app.Listen(addr)
+		}
+		if err != nil {
+			fmt.Printf("Failed to start the server: %v\n", err)
+		}
+		stop()
+	}()
+
+	<-ctx.Done()
+	shutdownTimeout := envDuration("SHUTDOWN_TIMEOUT", 10*time.Second)
+	fmt.Printf("Shutting down (timeout %s)...\n", shutdownTimeout)
+	close(streamsDone)
+	if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+		fmt.Printf("Error during shutdown: %v\n", err)
+	}
+	for _, w := range watchers {
+		w.Close()
 	}
+	removeEmbeddedFallbacks(categories)
 }
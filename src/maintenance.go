@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maintenance makes every content endpoint answer 503 (MAINTENANCE, or
+// toggled at runtime via /admin/maintenance).
+var maintenance atomic.Bool
+
+// maintenanceExempt lists the paths, relative to ROUTE_PREFIX, that keep
+// working during maintenance so the process can still be observed and the
+// mode switched back off.
+var maintenanceExempt = []string{"/health/live", "/info", "/metrics", "/openapi.json", "/admin"}
+
+// setMaintenance switches maintenance mode and logs when it actually changes.
+func setMaintenance(enabled bool, source string) {
+	if maintenance.Swap(enabled) == enabled {
+		return
+	}
+	state := "disabled"
+	if enabled {
+		state = "enabled"
+	}
+	fmt.Printf("Maintenance mode %s (%s)\n", state, source)
+}
+
+func maintenanceMiddleware(c *fiber.Ctx) error {
+	if !maintenance.Load() {
+		return c.Next()
+	}
+	for _, p := range maintenanceExempt {
+		if pathHasPrefix(c.Path(), routePrefix+p) {
+			return c.Next()
+		}
+	}
+	return sendError(c, fiber.StatusServiceUnavailable, "down for maintenance, try again later")
+}
+
+func serveMaintenanceHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"maintenance": maintenance.Load()})
+}
+
+// serveMaintenanceToggleHandler sets maintenance mode from a JSON body like
+// {"enabled": true}.
+func serveMaintenanceToggleHandler(c *fiber.Ctx) error {
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Enabled == nil {
+		return sendError(c, fiber.StatusBadRequest, `expected a JSON body like {"enabled": true}`)
+	}
+	setMaintenance(*body.Enabled, "admin endpoint")
+	return serveMaintenanceHandler(c)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type manifestEntry struct {
+	Name   string `json:"name"`
+	Number int    `json:"number"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// serveManifestHandler lists every image with its size and SHA-256 so clients
+// can tell whether their cached copies are current. Hashes share the ETag
+// cache, so they are computed once per file until the watcher sees a change.
+// The compress middleware gzips the response.
+func serveManifestHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		imageCacheMu.RLock()
+		sorted := sortedByNumber(cat.images)
+		imageCacheMu.RUnlock()
+
+		manifest := make([]manifestEntry, 0, len(sorted))
+		for _, name := range sorted {
+			fullPath := filepath.Join(cat.imageDir(), name)
+			info, err := cat.statImage(fullPath)
+			var sum string
+			if err == nil {
+				sum, err = cat.fileSHA256(fullPath)
+			}
+			// One failing read is enough to stop, rather than waiting out
+			// every image on a hung mount.
+			if err != nil && cat.breaker != nil && isStorageError(err) {
+				fmt.Printf("[%s] Failed to read %s: %v\n", cat.label, fullPath, err)
+				return sendStorageUnavailable(c)
+			}
+			if info == nil {
+				continue
+			}
+			if err != nil {
+				fmt.Printf("[%s] Skipping %s in manifest: %v\n", cat.label, name, err)
+				continue
+			}
+			manifest = append(manifest, manifestEntry{
+				Name:   name,
+				Number: extractNumberFromFilename(name),
+				Size:   info.Size(),
+				SHA256: sum,
+			})
+		}
+
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		return c.Status(fiber.StatusOK).JSON(manifest)
+	}
+}
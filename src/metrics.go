@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "garyapi_requests_total",
+		Help: "Total HTTP requests by route, method, and status.",
+	}, []string{"route", "method", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "garyapi_request_duration_seconds",
+		Help:    "HTTP request latency by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	imagesServedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "garyapi_images_served_total",
+		Help: "Images selected or streamed, by category.",
+	}, []string{"category"})
+
+	defaultServedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "garyapi_default_served_total",
+		Help: "Times the fallback default image was picked because a category had no images.",
+	}, []string{"category"})
+
+	corruptSkippedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "garyapi_corrupt_images_skipped_total",
+		Help: "Random picks re-rolled because the image header failed to decode, by category.",
+	}, []string{"category"})
+)
+
+// metricsMiddleware records request counts and latency, labelled by the
+// registered route pattern so path parameters don't explode cardinality. The
+// latency also feeds the /info snapshot.
+func metricsMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			var fiberErr *fiber.Error
+			if errors.As(err, &fiberErr) {
+				status = fiberErr.Code
+			} else {
+				status = fiber.StatusInternalServerError
+			}
+		}
+
+		route := c.Route().Path
+		elapsed := time.Since(start)
+		requestsTotal.WithLabelValues(route, c.Method(), strconv.Itoa(status)).Inc()
+		requestDuration.WithLabelValues(route, c.Method()).Observe(elapsed.Seconds())
+		recordLatency(c.Method()+" "+route, elapsed)
+		return err
+	}
+}
+
+func recordImageServed(cat *Category) {
+	imagesServedTotal.WithLabelValues(cat.name).Inc()
+}
+
+// recordDefaultServed warns and counts when cat has no images and its default
+// is used instead, so an emptied directory shows up before users notice.
+func recordDefaultServed(cat *Category, name string) {
+	fmt.Printf("[%s] WARNING: no images available, serving default %s\n", cat.label, name)
+	defaultServedTotal.WithLabelValues(cat.name).Inc()
+}
+
+func recordCorruptSkipped(cat *Category) {
+	corruptSkippedTotal.WithLabelValues(cat.name).Inc()
+}
+
+func metricsHandler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.Handler())
+}
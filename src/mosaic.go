@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/image/draw"
+)
+
+const (
+	mosaicCellSize    = 200
+	maxMosaicRows     = 6
+	maxMosaicCols     = 6
+	defaultMosaicRows = 2
+	defaultMosaicCols = 3
+	// defaultMosaicCacheBytes fits about 200 cells.
+	defaultMosaicCacheBytes = 32 << 20
+)
+
+var (
+	// mosaicEnabled registers /<category>/mosaic (MOSAIC_ENABLED). Composing
+	// is CPU-heavy, so it is off by default.
+	mosaicEnabled bool
+
+	// mosaicCells caches images scaled and center-cropped to one cell, by
+	// source path, up to MOSAIC_CACHE_BYTES. The picks are random, so only
+	// cells can be reused.
+	mosaicCells = newLRUCache(defaultMosaicCacheBytes, func(cell *image.RGBA) int64 { return int64(len(cell.Pix)) })
+
+	mosaicBackground = color.RGBA{0xe2, 0xe8, 0xf0, 0xff}
+)
+
+// mosaicCell returns the image at path filled into a square cell.
+func mosaicCell(cat *Category, path string) (*image.RGBA, error) {
+	if cell, ok := mosaicCells.get(path); ok {
+		return cell, nil
+	}
+
+	src, _, err := cat.decodeImage(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Crop the largest centered square, then scale it to the cell.
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x0 := bounds.Min.X + (bounds.Dx()-side)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-side)/2
+	cell := image.NewRGBA(image.Rect(0, 0, mosaicCellSize, mosaicCellSize))
+	draw.CatmullRom.Scale(cell, cell.Bounds(), src, image.Rect(x0, y0, x0+side, y0+side), draw.Src, nil)
+
+	mosaicCells.put(path, cell)
+	return cell, nil
+}
+
+func invalidateMosaicCell(path string) {
+	mosaicCells.remove(path)
+}
+
+// serveMosaicHandler tiles rows*cols random images into one JPEG, or PNG
+// with ?format=png. Cells that fail to decode are left blank.
+func serveMosaicHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rows := c.QueryInt("rows", defaultMosaicRows)
+		cols := c.QueryInt("cols", defaultMosaicCols)
+		if rows < 1 || rows > maxMosaicRows || cols < 1 || cols > maxMosaicCols {
+			return sendError(c, fiber.StatusBadRequest, fmt.Sprintf("rows must be between 1 and %d and cols between 1 and %d", maxMosaicRows, maxMosaicCols))
+		}
+		format := c.Query("format", "jpeg")
+		if format != "jpeg" && format != "png" {
+			return sendError(c, fiber.StatusBadRequest, "format must be jpeg or png")
+		}
+
+		pool, filterErr := filterPool(c, cat)
+		if filterErr != nil {
+			return sendError(c, filterErr.Code, filterErr.Message)
+		}
+		imageCacheMu.RLock()
+		if len(pool) == 0 {
+			imageCacheMu.RUnlock()
+			return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no images in %s", cat.name))
+		}
+		// Avoid duplicate tiles whenever there are enough images.
+		picks := sampleImages(cat, pool, rows*cols, len(pool) >= rows*cols)
+		for len(picks) < rows*cols {
+			picks = append(picks, pickRandomFileName(cat, pool))
+		}
+		imageCacheMu.RUnlock()
+
+		mosaic := image.NewRGBA(image.Rect(0, 0, cols*mosaicCellSize, rows*mosaicCellSize))
+		draw.Draw(mosaic, mosaic.Bounds(), image.NewUniform(mosaicBackground), image.Point{}, draw.Src)
+		for i, name := range picks {
+			cell, err := mosaicCell(cat, filepath.Join(cat.imageDir(), name))
+			if err != nil {
+				fmt.Printf("[%s] Leaving mosaic cell blank: %v\n", cat.label, err)
+				continue
+			}
+			at := image.Pt((i%cols)*mosaicCellSize, (i/cols)*mosaicCellSize)
+			draw.Draw(mosaic, cell.Bounds().Add(at), cell, image.Point{}, draw.Src)
+		}
+
+		var buf bytes.Buffer
+		contentType := "image/jpeg"
+		var err error
+		if format == "png" {
+			contentType = "image/png"
+			err = png.Encode(&buf, mosaic)
+		} else {
+			err = jpeg.Encode(&buf, mosaic, &jpeg.Options{Quality: 85})
+		}
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, "could not encode mosaic")
+		}
+		c.Set(fiber.HeaderCacheControl, randomCacheControl)
+		c.Set(fiber.HeaderContentType, contentType)
+		return c.Status(fiber.StatusOK).Send(buf.Bytes())
+	}
+}
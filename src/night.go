@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nightHours is the NIGHT_HOURS window, in server-local hours. It may wrap
+// past midnight (20-6). Night mode stays off unless enabled is set.
+var nightHours struct {
+	enabled    bool
+	start, end int
+	tag        string
+}
+
+// parseNightHours reads a range like "20-6". start == end is rejected since it
+// would be ambiguous between never and always.
+func parseNightHours(raw, tag string) error {
+	startRaw, endRaw, ok := strings.Cut(raw, "-")
+	if !ok {
+		return fmt.Errorf("expected START-END, got %q", raw)
+	}
+	start, err1 := strconv.Atoi(strings.TrimSpace(startRaw))
+	end, err2 := strconv.Atoi(strings.TrimSpace(endRaw))
+	if err1 != nil || err2 != nil || start < 0 || start > 23 || end < 0 || end > 23 || start == end {
+		return fmt.Errorf("expected two different hours between 0 and 23, got %q", raw)
+	}
+	nightHours.enabled = true
+	nightHours.start, nightHours.end = start, end
+	nightHours.tag = tag
+	return nil
+}
+
+func isNight(now time.Time) bool {
+	hour := now.Hour()
+	if nightHours.start < nightHours.end {
+		return hour >= nightHours.start && hour < nightHours.end
+	}
+	return hour >= nightHours.start || hour < nightHours.end
+}
+
+// applyNightBias prefers night-tagged images during NIGHT_HOURS and the rest
+// outside it. When the preferred side is empty the pool is used unchanged.
+func applyNightBias(pool []string, now time.Time) []string {
+	if !nightHours.enabled {
+		return pool
+	}
+	night := isNight(now)
+	var preferred []string
+	for _, name := range pool {
+		if tagMatcher(name, nightHours.tag) == night {
+			preferred = append(preferred, name)
+		}
+	}
+	if len(preferred) == 0 {
+		return pool
+	}
+	return preferred
+}
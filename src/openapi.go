@@ -0,0 +1,18 @@
+package main
+
+import (
+	_ "embed"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// openAPISpec is the hand-maintained OpenAPI 3.0 description of every route.
+// Keep it in step with the handlers when adding endpoints or query params.
+//
+//go:embed assets/openapi.json
+var openAPISpec []byte
+
+func serveOpenAPIHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Status(fiber.StatusOK).Send(openAPISpec)
+}
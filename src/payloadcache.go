@@ -0,0 +1,96 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// payloadCacheSize is the per-category number of pre-encoded JSON payloads
+// kept by PAYLOAD_CACHE_SIZE. 0 disables the cache.
+var payloadCacheSize int
+
+type payloadEntry struct {
+	name string
+	body []byte
+}
+
+// payloadCache is a small LRU of encoded imageURLPayload responses keyed by
+// image name. A nil *payloadCache is valid and caches nothing.
+type payloadCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newPayloadCache(size int) *payloadCache {
+	if size <= 0 {
+		return nil
+	}
+	return &payloadCache{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (pc *payloadCache) get(name string) ([]byte, bool) {
+	if pc == nil {
+		return nil, false
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	el, ok := pc.entries[name]
+	if !ok {
+		return nil, false
+	}
+	pc.order.MoveToFront(el)
+	return el.Value.(*payloadEntry).body, true
+}
+
+func (pc *payloadCache) put(name string, body []byte) {
+	if pc == nil {
+		return
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if el, ok := pc.entries[name]; ok {
+		el.Value.(*payloadEntry).body = body
+		pc.order.MoveToFront(el)
+		return
+	}
+	pc.entries[name] = pc.order.PushFront(&payloadEntry{name: name, body: body})
+	if pc.order.Len() > pc.size {
+		oldest := pc.order.Back()
+		pc.order.Remove(oldest)
+		delete(pc.entries, oldest.Value.(*payloadEntry).name)
+	}
+}
+
+func (pc *payloadCache) clear() {
+	if pc == nil {
+		return
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.order.Init()
+	clear(pc.entries)
+}
+
+// sendImageURLPayload writes imageURLPayload for imageName, reusing the
+// encoded body from the category's payload cache when enabled.
+func sendImageURLPayload(c *fiber.Ctx, cat *Category, imageName string) error {
+	if cat.payloads == nil {
+		return c.Status(fiber.StatusOK).JSON(imageURLPayload(cat, imageName))
+	}
+	body, ok := cat.payloads.get(imageName)
+	if !ok {
+		var err error
+		body, err = json.Marshal(imageURLPayload(cat, imageName))
+		if err != nil {
+			return err
+		}
+		cat.payloads.put(imageName, body)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Status(fiber.StatusOK).Send(body)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	_ "embed"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// placeholderPNG is a transparent 1x1 image served when a category has no
+// images and its default file is missing too.
+//
+//go:embed assets/placeholder.png
+var placeholderPNG []byte
+
+func sendPlaceholder(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "image/png")
+	c.Set("X-Placeholder", "true")
+	return c.Status(fiber.StatusOK).Send(placeholderPNG)
+}
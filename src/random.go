@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"sync"
+)
+
+var (
+	// seededRand is set only when RANDOM_SEED is configured. *rand.Rand is not
+	// safe for concurrent use, so it is guarded by seededRandMu.
+	seededRand   *rand.Rand
+	seededRandMu sync.Mutex
+)
+
+// seedRandomFromEnv switches every random pick to a deterministic source when
+// RANDOM_SEED is set. This is meant for reproducible tests, not production.
+func seedRandomFromEnv() {
+	raw := os.Getenv("RANDOM_SEED")
+	if raw == "" {
+		return
+	}
+	seed, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		fmt.Printf("Invalid RANDOM_SEED %q, staying nondeterministic: %v\n", raw, err)
+		return
+	}
+	seededRand = rand.New(rand.NewPCG(seed, seed))
+	fmt.Printf("Using RANDOM_SEED=%d, random picks are reproducible (testing only)\n", seed)
+}
+
+func randIntN(n int) int {
+	if seededRand == nil {
+		return rand.IntN(n)
+	}
+	seededRandMu.Lock()
+	defer seededRandMu.Unlock()
+	return seededRand.IntN(n)
+}
+
+func randPerm(n int) []int {
+	if seededRand == nil {
+		return rand.Perm(n)
+	}
+	seededRandMu.Lock()
+	defer seededRandMu.Unlock()
+	return seededRand.Perm(n)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+const defaultRateLimitExempt = "/health,/metrics"
+
+// pathHasPrefix reports whether path equals prefix or sits below it.
+func pathHasPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// newRateLimiter builds the per-IP limiter from RATE_LIMIT (requests per
+// window), RATE_WINDOW, and RATE_LIMIT_EXEMPT. It returns nil when RATE_LIMIT
+// is unset or zero.
+//
+// Requests are keyed on c.IP(), which only reads the proxy header for
+// requests from TRUSTED_PROXIES. The first X-Forwarded-For hop is whatever
+// the client sent, so RATE_LIMIT_TRUST_PROXY is no longer honored.
+func newRateLimiter() fiber.Handler {
+	max := envInt("RATE_LIMIT", 0)
+	if max <= 0 {
+		return nil
+	}
+	window := envDuration("RATE_WINDOW", time.Minute)
+	if os.Getenv("RATE_LIMIT_TRUST_PROXY") == "true" {
+		fmt.Println("RATE_LIMIT_TRUST_PROXY is ignored, set TRUSTED_PROXIES to rate limit behind a proxy")
+	}
+
+	exemptRaw := os.Getenv("RATE_LIMIT_EXEMPT")
+	if exemptRaw == "" {
+		exemptRaw = defaultRateLimitExempt
+	}
+	var exempt []string
+	for _, p := range strings.Split(exemptRaw, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			exempt = append(exempt, p)
+		}
+	}
+
+	return limiter.New(limiter.Config{
+		Max:        max,
+		Expiration: window,
+		Next: func(c *fiber.Ctx) bool {
+			for _, p := range exempt {
+				if pathHasPrefix(c.Path(), routePrefix+p) {
+					return true
+				}
+			}
+			return false
+		},
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return c.IP()
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			return sendError(c, fiber.StatusTooManyRequests, "rate limit exceeded")
+		},
+	})
+}
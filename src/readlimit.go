@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	// maxConcurrentReads caps simultaneous image file reads per category
+	// (MAX_CONCURRENT_READS). 0 means unlimited.
+	maxConcurrentReads int
+	// readWaitTimeout is how long a request waits for a free read slot
+	// before it is answered with 503.
+	readWaitTimeout = 100 * time.Millisecond
+)
+
+func newReadSlots(limit int) chan struct{} {
+	if limit <= 0 {
+		return nil
+	}
+	return make(chan struct{}, limit)
+}
+
+// limitReads holds one of cat's read slots while handler runs. fasthttp may
+// finish streaming a large file after the handler returns, so this bounds
+// files being opened and buffered rather than bytes in flight. While cat's
+// circuit breaker is open, requests are answered with 503 without a read.
+func limitReads(cat *Category, handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		probe, ok := cat.breaker.allow()
+		if !ok {
+			return sendStorageFailing(c, cat)
+		}
+		defer cat.breaker.release(probe)
+		return cat.withReadSlot(c, handler)
+	}
+}
+
+// withReadSlot runs handler once one of cat's read slots is free, or answers
+// 503 after readWaitTimeout.
+func (cat *Category) withReadSlot(c *fiber.Ctx, handler fiber.Handler) error {
+	if cat.reads == nil {
+		return handler(c)
+	}
+	timer := time.NewTimer(readWaitTimeout)
+	defer timer.Stop()
+	select {
+	case cat.reads <- struct{}{}:
+	case <-timer.C:
+		return sendError(c, fiber.StatusServiceUnavailable, "too many concurrent image reads, try again shortly")
+	}
+	defer func() { <-cat.reads }()
+	return handler(c)
+}
+
+func sendStorageFailing(c *fiber.Ctx, cat *Category) error {
+	return sendError(c, fiber.StatusServiceUnavailable, fmt.Sprintf("%s image storage is failing, try again shortly", cat.name))
+}
+
+// sendStorageUnavailable answers for a read that failed on cat's storage.
+func sendStorageUnavailable(c *fiber.Ctx) error {
+	return sendError(c, fiber.StatusServiceUnavailable, "image storage is unavailable, try again shortly")
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadOnSIGHUP re-reads every line file and rescans every category each
+// time the process gets SIGHUP, as a deterministic alternative to waiting on
+// the file watchers. The per-file caches are dropped too, since content may
+// have been swapped in place.
+func reloadOnSIGHUP(categories []*Category, lineFiles map[string]*LineFile) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			fmt.Println("SIGHUP received, reloading content...")
+			clearFileCaches()
+			for name, lf := range lineFiles {
+				if err := lf.reload(); err == nil {
+					fmt.Printf("[%s] Reloaded %d lines\n", name, lf.count())
+				}
+			}
+			for _, cat := range categories {
+				cat.warmUp(false)
+			}
+		}
+	}()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type imageSize struct {
+	Category string `json:"category,omitempty"`
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+}
+
+type categoryStats struct {
+	Images   int        `json:"images"`
+	Bytes    int64      `json:"bytes"`
+	Largest  *imageSize `json:"largest,omitempty"`
+	Smallest *imageSize `json:"smallest,omitempty"`
+	// Updated is the newest modification time of the directory or any image
+	// in it, so deletions count too.
+	Updated time.Time `json:"updated,omitzero"`
+}
+
+// contentStats returns the cached size stats for cat, recomputing them with
+// cat.statImage after the watcher or a rescan has invalidated them. The
+// names are copied first so no stat runs under imageCacheMu. A storage
+// error stops the walk, and the partial result isn't cached.
+func (cat *Category) contentStats() categoryStats {
+	cat.statsMu.Lock()
+	defer cat.statsMu.Unlock()
+	if cat.stats != nil {
+		return *cat.stats
+	}
+
+	imageCacheMu.RLock()
+	images := cat.images
+	dir := cat.imageDir()
+	imageCacheMu.RUnlock()
+
+	stats := categoryStats{Images: len(images)}
+	info, err := cat.statImage(dir)
+	if err == nil {
+		stats.Updated = info.ModTime().UTC()
+	}
+	for _, name := range images {
+		if isStorageError(err) {
+			return stats
+		}
+		info, err = cat.statImage(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		if modTime := info.ModTime().UTC(); modTime.After(stats.Updated) {
+			stats.Updated = modTime
+		}
+		stats.Bytes += info.Size()
+		if stats.Largest == nil || info.Size() > stats.Largest.Size {
+			stats.Largest = &imageSize{Name: name, Size: info.Size()}
+		}
+		if stats.Smallest == nil || info.Size() < stats.Smallest.Size {
+			stats.Smallest = &imageSize{Name: name, Size: info.Size()}
+		}
+	}
+	if isStorageError(err) {
+		return stats
+	}
+
+	cat.stats = &stats
+	return stats
+}
+
+// serveUpdatedHandler reports when cat's content last changed, for clients
+// that use it as a cache-busting key.
+func serveUpdatedHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		updated := cat.contentStats().Updated
+		if updated.IsZero() {
+			return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no modification time available for %s", cat.name))
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"updated": updated.Format(time.RFC3339)})
+	}
+}
+
+func (cat *Category) invalidateStats() {
+	cat.statsMu.Lock()
+	cat.stats = nil
+	cat.statsMu.Unlock()
+}
+
+// serveStatsHandler reports content metrics for a dashboard: image counts and
+// bytes per category, the largest and smallest images, and line counts.
+// Runtime details live in /info.
+func serveStatsHandler(categories []*Category, lineFiles map[string]*LineFile) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "no-store")
+
+		perCategory := make(map[string]categoryStats, len(categories))
+		var imagesTotal int
+		var bytesTotal int64
+		var largest, smallest *imageSize
+		for _, cat := range categories {
+			stats := cat.contentStats()
+			perCategory[cat.name] = stats
+			imagesTotal += stats.Images
+			bytesTotal += stats.Bytes
+			if stats.Largest != nil && (largest == nil || stats.Largest.Size > largest.Size) {
+				largest = &imageSize{Category: cat.name, Name: stats.Largest.Name, Size: stats.Largest.Size}
+			}
+			if stats.Smallest != nil && (smallest == nil || stats.Smallest.Size < smallest.Size) {
+				smallest = &imageSize{Category: cat.name, Name: stats.Smallest.Name, Size: stats.Smallest.Size}
+			}
+		}
+
+		resp := fiber.Map{
+			"categories":   perCategory,
+			"images_total": imagesTotal,
+			"bytes_total":  bytesTotal,
+			"largest":      largest,
+			"smallest":     smallest,
+		}
+		for name, lf := range lineFiles {
+			resp[name] = lf.count()
+		}
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+}
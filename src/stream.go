@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultStreamMaxClients = 100
+	streamHeartbeat         = 15 * time.Second
+)
+
+var (
+	// streamMaxClients caps concurrent /<category>/stream connections across
+	// all categories (STREAM_MAX_CLIENTS).
+	streamMaxClients = defaultStreamMaxClients
+	streamClients    atomic.Int64
+
+	// streamsDone is closed on shutdown so open streams end instead of
+	// holding the server open until the shutdown timeout.
+	streamsDone = make(chan struct{})
+)
+
+// subscribe registers a channel that receives every event added to l from
+// now on. Call the returned function to unsubscribe.
+func (l *eventLog) subscribe() (<-chan watchEvent, func()) {
+	ch := make(chan watchEvent, 16)
+	l.mu.Lock()
+	if l.subscribers == nil {
+		l.subscribers = map[chan watchEvent]struct{}{}
+	}
+	l.subscribers[ch] = struct{}{}
+	l.mu.Unlock()
+	return ch, func() {
+		l.mu.Lock()
+		delete(l.subscribers, ch)
+		l.mu.Unlock()
+	}
+}
+
+// serveStreamHandler pushes cat's watcher events as Server-Sent Events, one
+// "change" event per fsnotify event, so displays can refresh without polling.
+func serveStreamHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if streamClients.Add(1) > int64(streamMaxClients) {
+			streamClients.Add(-1)
+			return sendError(c, fiber.StatusServiceUnavailable, "too many stream clients, try again later")
+		}
+		events, unsubscribe := cat.events.subscribe()
+
+		c.Set(fiber.HeaderContentType, "text/event-stream")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		c.Set(fiber.HeaderConnection, "keep-alive")
+		c.Set("X-Accel-Buffering", "no")
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer streamClients.Add(-1)
+			defer unsubscribe()
+
+			heartbeat := time.NewTicker(streamHeartbeat)
+			defer heartbeat.Stop()
+			fmt.Fprint(w, "retry: 3000\n\n")
+			for {
+				if w.Flush() != nil {
+					return
+				}
+				select {
+				case event := <-events:
+					data, _ := json.Marshal(event)
+					fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+				case <-heartbeat.C:
+					// Comments keep proxies from timing out and reveal
+					// disconnected clients on the next flush.
+					fmt.Fprint(w, ": ping\n\n")
+				case <-streamsDone:
+					return
+				}
+			}
+		})
+		return nil
+	}
+}
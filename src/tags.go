@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// tagMatcher reports whether an image carries tag. It and tagExtractor are
+// variables so a different naming scheme can be plugged in.
+var tagMatcher = func(name, tag string) bool {
+	return strings.Contains(strings.ToLower(name), strings.ToLower(tag))
+}
+
+// tagExtractor derives tags from a filename like Gary_sleeping_12.jpg by
+// splitting on non-letters and dropping the category name.
+var tagExtractor = func(categoryName, name string) []string {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	var tags []string
+	for _, word := range strings.FieldsFunc(base, func(r rune) bool { return !unicode.IsLetter(r) }) {
+		word = strings.ToLower(word)
+		if len(word) < 2 || word == categoryName {
+			continue
+		}
+		tags = append(tags, word)
+	}
+	return tags
+}
+
+func filterByTag(images []string, tag string) []string {
+	var matched []string
+	for _, name := range images {
+		if tagMatcher(name, tag) {
+			matched = append(matched, name)
+		}
+	}
+	return matched
+}
+
+// distinctTags lists every tag found across images, sorted.
+func distinctTags(categoryName string, images []string) []string {
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, name := range images {
+		for _, tag := range tagExtractor(categoryName, name) {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	slices.Sort(tags)
+	return tags
+}
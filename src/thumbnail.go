@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/image/draw"
+)
+
+const (
+	defaultThumbWidth = 200
+	maxThumbWidth     = 1024
+)
+
+// thumbWidths are the widths thumbnails are actually rendered at. A requested
+// width is rounded down to one of them, so each image has at most this many
+// cached thumbnails and only a ?w= below the smallest comes back wider.
+var thumbWidths = []int{64, 128, 200, 320, 480, 640, 800, maxThumbWidth}
+
+// thumbnail is a rendered thumbnail, or with nil data a record that the
+// image is already no wider than the width and is sent as-is.
+type thumbnail struct {
+	data        []byte
+	contentType string
+}
+
+var (
+	// thumbCache holds generated thumbnails by source path, then width.
+	thumbCache   = map[string]map[int]thumbnail{}
+	thumbCacheMu sync.RWMutex
+)
+
+// thumbWidth rounds width down to the largest of thumbWidths that fits, or
+// up to the smallest when width is below it.
+func thumbWidth(width int) int {
+	fit := thumbWidths[0]
+	for _, w := range thumbWidths[1:] {
+		if w <= width {
+			fit = w
+		}
+	}
+	return fit
+}
+
+// makeThumbnail scales the image at path down to width, keeping its aspect
+// ratio. JPEGs stay JPEG; everything else is encoded as PNG. It reports false
+// when the image is already no wider than width.
+func makeThumbnail(cat *Category, path string, width int) (thumbnail, bool, error) {
+	thumbCacheMu.RLock()
+	thumb, ok := thumbCache[path][width]
+	thumbCacheMu.RUnlock()
+	if ok {
+		return thumb, thumb.data != nil, nil
+	}
+
+	// The header is enough to tell the image needs no resizing.
+	if dims, err := cat.imageDimensions(path); err == nil && dims.width <= width {
+		cacheThumbnail(path, width, thumbnail{})
+		return thumbnail{}, false, nil
+	}
+	src, format, err := cat.decodeImage(path)
+	if err != nil {
+		return thumbnail{}, false, err
+	}
+
+	bounds := src.Bounds()
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+		thumb.contentType = "image/jpeg"
+	} else {
+		err = png.Encode(&buf, dst)
+		thumb.contentType = "image/png"
+	}
+	if err != nil {
+		return thumbnail{}, false, fmt.Errorf("could not encode thumbnail of %s: %w", path, err)
+	}
+	thumb.data = buf.Bytes()
+	cacheThumbnail(path, width, thumb)
+	return thumb, true, nil
+}
+
+func cacheThumbnail(path string, width int, thumb thumbnail) {
+	thumbCacheMu.Lock()
+	if thumbCache[path] == nil {
+		thumbCache[path] = map[int]thumbnail{}
+	}
+	thumbCache[path][width] = thumb
+	thumbCacheMu.Unlock()
+}
+
+func invalidateThumbnails(path string) {
+	thumbCacheMu.Lock()
+	delete(thumbCache, path)
+	thumbCacheMu.Unlock()
+}
+
+func serveThumbnailHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		number, err := c.ParamsInt("id")
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, "invalid image number")
+		}
+		width := c.QueryInt("w", defaultThumbWidth)
+		if width < 1 || width > maxThumbWidth {
+			return sendError(c, fiber.StatusBadRequest, fmt.Sprintf("w must be between 1 and %d", maxThumbWidth))
+		}
+
+		imageCacheMu.RLock()
+		imageName, ok := findImageByNumber(cat.images, number)
+		imageCacheMu.RUnlock()
+		if !ok {
+			return sendError(c, fiber.StatusNotFound, fmt.Sprintf("no image with number %d", number))
+		}
+		recordImageServed(cat)
+
+		fullPath := filepath.Join(cat.imageDir(), imageName)
+		c.Set(fiber.HeaderCacheControl, imageCacheControl)
+		setImageHeaders(c, imageName)
+		// GIFs would lose their animation, so they are always sent as-is.
+		if strings.EqualFold(filepath.Ext(imageName), ".gif") {
+			return sendImageFile(c, cat, fullPath)
+		}
+		thumb, resized, err := makeThumbnail(cat, fullPath, thumbWidth(width))
+		if err != nil {
+			fmt.Printf("[%s] Thumbnail failed, sending original: %v\n", cat.label, err)
+		}
+		if !resized {
+			return sendImageFile(c, cat, fullPath)
+		}
+		return sendImageBytes(c, thumb.data, thumb.contentType)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultUploadMaxBytes = 10 << 20
+
+var (
+	uploadToken    string
+	uploadMaxBytes int64 = defaultUploadMaxBytes
+
+	allowedUploadTypes = map[string]bool{
+		"image/jpeg": true,
+		"image/png":  true,
+		"image/gif":  true,
+		"image/webp": true,
+	}
+)
+
+// requireUploadToken guards write endpoints behind "Authorization: Bearer
+// <UPLOAD_TOKEN>". Without a configured token the endpoints are disabled.
+func requireUploadToken(c *fiber.Ctx) error {
+	if uploadToken == "" {
+		return sendError(c, fiber.StatusForbidden, "uploads are disabled")
+	}
+	token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !ok || !validUploadToken(token) {
+		return sendError(c, fiber.StatusUnauthorized, "missing or invalid token")
+	}
+	return c.Next()
+}
+
+func validUploadToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(uploadToken)) == 1
+}
+
+// sniffUploadType reads the first bytes of the upload and returns its
+// detected content type.
+func sniffUploadType(r io.Reader) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+func serveImageUploadHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Uploads always go to the category's own directory, so one into an
+		// empty directory takes over from the fallback on the rescan below.
+		if cat.dir == "" {
+			return sendError(c, fiber.StatusForbidden, fmt.Sprintf("%s_DIR is not set", envPrefix(cat.name)))
+		}
+		file, err := c.FormFile("file")
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, "missing multipart file field \"file\"")
+		}
+		if file.Size > uploadMaxBytes {
+			return sendError(c, fiber.StatusRequestEntityTooLarge, fmt.Sprintf("file exceeds the %d byte limit", uploadMaxBytes))
+		}
+
+		name := filepath.Base(file.Filename)
+		if !hasAllowedExtension(name) {
+			return sendError(c, fiber.StatusUnsupportedMediaType, "file extension is not an allowed image type")
+		}
+		fullPath, err := resolveImagePath(cat.dir, name)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, err.Error())
+		}
+
+		src, err := file.Open()
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, "could not read upload")
+		}
+		defer src.Close()
+
+		contentType, err := sniffUploadType(src)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, "could not read upload")
+		}
+		if !allowedUploadTypes[contentType] {
+			return sendError(c, fiber.StatusUnsupportedMediaType, fmt.Sprintf("content type %s is not an allowed image format", contentType))
+		}
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return sendError(c, fiber.StatusInternalServerError, "could not read upload")
+		}
+
+		dst, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			if os.IsExist(err) {
+				return sendError(c, fiber.StatusConflict, fmt.Sprintf("an image named %s already exists", name))
+			}
+			fmt.Printf("[%s] Failed to create %s: %v\n", cat.label, fullPath, err)
+			return sendError(c, fiber.StatusInternalServerError, "could not save image")
+		}
+		_, copyErr := io.Copy(dst, src)
+		closeErr := dst.Close()
+		if copyErr != nil || closeErr != nil {
+			os.Remove(fullPath)
+			fmt.Printf("[%s] Failed to write %s: %v %v\n", cat.label, fullPath, copyErr, closeErr)
+			return sendError(c, fiber.StatusInternalServerError, "could not save image")
+		}
+
+		cat.rescan()
+		fmt.Printf("[%s] Uploaded %s\n", cat.label, name)
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"url":    buildImageURL(cat.baseURL, name),
+			"number": extractNumberFromFilename(name),
+		})
+	}
+}
+
+func serveImageDeleteHandler(cat *Category) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		number, err := c.ParamsInt("id")
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, "invalid image number")
+		}
+
+		name, deleteErr := cat.deleteImage(number)
+		if deleteErr != nil {
+			return sendError(c, deleteErr.Code, deleteErr.Message)
+		}
+		// Like a rescan, cleared outside imageCacheMu: contentStats takes
+		// statsMu before it.
+		cat.payloads.clear()
+		cat.invalidateStats()
+
+		fmt.Printf("[%s] Deleted %s\n", cat.label, name)
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"deleted": name, "number": number})
+	}
+}
+
+// deleteImage removes the image numbered number from cat's own directory and
+// drops it from the cached lists. While the fallback directory or the
+// embedded set is serving, cat.images lists that instead, so nothing is
+// deleted.
+func (cat *Category) deleteImage(number int) (string, *fiber.Error) {
+	imageCacheMu.Lock()
+	defer imageCacheMu.Unlock()
+
+	switch cat.source.Load() {
+	case sourceFallback:
+		return "", fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("%s is serving from its fallback directory", cat.name))
+	case sourceEmbedded:
+		return "", fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("%s is serving the embedded fallback set", cat.name))
+	}
+	name, ok := findImageByNumber(cat.images, number)
+	if !ok {
+		return "", fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("no image with number %d", number))
+	}
+	if slices.Contains(cat.defaultImages, name) {
+		return "", fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("%s is the default image and cannot be deleted", name))
+	}
+
+	fullPath := filepath.Join(cat.dir, name)
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("[%s] Failed to delete %s: %v\n", cat.label, fullPath, err)
+		return "", fiber.NewError(fiber.StatusInternalServerError, "could not delete image")
+	}
+	cat.images = slices.DeleteFunc(slices.Clone(cat.images), func(n string) bool { return n == name })
+	cat.pool = slices.DeleteFunc(slices.Clone(cat.pool), func(n string) bool { return n == name })
+	invalidateFileCaches(fullPath)
+	return name, nil
+}
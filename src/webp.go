@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	// webpTranscode enables ?format=webp on the random image route
+	// (WEBP_TRANSCODE=true). Without it the original bytes are sent.
+	webpTranscode bool
+
+	// webpCache holds transcodes by source path, least recently used first
+	// out once they pass WEBP_CACHE_BYTES in total.
+	webpCache = newLRUCache(defaultWebPCacheBytes, func(data []byte) int64 { return int64(len(data)) })
+)
+
+const defaultWebPCacheBytes = 64 << 20
+
+func canTranscodeWebP(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	}
+	return false
+}
+
+// transcodeToWebP encodes the image at path as lossless WebP, caching the
+// result by path until the watcher invalidates or the LRU evicts it.
+func transcodeToWebP(cat *Category, path string) ([]byte, error) {
+	if data, ok := webpCache.get(path); ok {
+		return data, nil
+	}
+
+	img, _, err := cat.decodeImage(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := nativewebp.Encode(&buf, img, nil); err != nil {
+		return nil, fmt.Errorf("could not encode %s as webp: %w", path, err)
+	}
+	data := buf.Bytes()
+	webpCache.put(path, data)
+	return data, nil
+}
+
+// sendWebP streams path as WebP, falling back to the original bytes when it
+// isn't a JPG/PNG or transcoding fails.
+func sendWebP(c *fiber.Ctx, cat *Category, path string) error {
+	if !canTranscodeWebP(path) {
+		return sendImageFile(c, cat, path)
+	}
+	data, err := transcodeToWebP(cat, path)
+	if err != nil {
+		fmt.Printf("WebP transcoding failed, sending original: %v\n", err)
+		return sendImageFile(c, cat, path)
+	}
+	return sendImageBytes(c, data, "image/webp")
+}
+
+func invalidateWebPCache(path string) {
+	webpCache.remove(path)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultWSInterval    = 5 * time.Second
+	defaultWSMinInterval = time.Second
+	defaultWSMaxClients  = 100
+	wsWriteTimeout       = 10 * time.Second
+)
+
+var (
+	// wsMinInterval is the shortest ?interval= a /<category>/ws client may ask
+	// for (WS_MIN_INTERVAL). It is always positive, since time.NewTicker
+	// panics on anything else.
+	wsMinInterval = defaultWSMinInterval
+	// wsMaxClients caps concurrent /<category>/ws connections across all
+	// categories (WS_MAX_CLIENTS).
+	wsMaxClients = defaultWSMaxClients
+	wsClients    atomic.Int64
+)
+
+// serveImageSocketHandler upgrades to a WebSocket that pushes a random image
+// payload of cat right away and then every ?interval= (default 5s), so a
+// kiosk display only has to render what arrives. Messages from the client
+// are ignored.
+func serveImageSocketHandler(cat *Category) fiber.Handler {
+	push := websocket.New(func(conn *websocket.Conn) {
+		defer wsClients.Add(-1)
+		interval := conn.Locals("interval").(time.Duration)
+
+		// The read loop only notices the client going away.
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			imageCacheMu.RLock()
+			imageName := pickRandomFileName(cat, cat.pool)
+			imageCacheMu.RUnlock()
+			// With nothing to serve, stay connected and try again next tick.
+			if imageName != "" {
+				recordImageServed(cat)
+				conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				if err := conn.WriteJSON(imageURLPayload(cat, imageName)); err != nil {
+					return
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-closed:
+				return
+			case <-streamsDone:
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(time.Second))
+				return
+			}
+		}
+	})
+
+	return func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			return sendError(c, fiber.StatusUpgradeRequired, "expected a WebSocket upgrade")
+		}
+		interval := max(defaultWSInterval, wsMinInterval)
+		if raw := c.Query("interval"); raw != "" {
+			var err error
+			if interval, err = time.ParseDuration(raw); err != nil || interval <= 0 || interval < wsMinInterval {
+				return sendError(c, fiber.StatusBadRequest, fmt.Sprintf("interval must be a duration of at least %s", wsMinInterval))
+			}
+		}
+		if wsClients.Add(1) > int64(wsMaxClients) {
+			wsClients.Add(-1)
+			return sendError(c, fiber.StatusServiceUnavailable, "too many WebSocket clients, try again later")
+		}
+		c.Locals("interval", interval)
+		// push only returns an error when the handshake fails, in which case
+		// the connection handler (and its deferred release) never runs.
+		if err := push(c); err != nil {
+			wsClients.Add(-1)
+			return err
+		}
+		return nil
+	}
+}